@@ -3,9 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/jonbern/go-example-api/pkg/tutils"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -33,6 +36,76 @@ func setup() (*httptest.Server, func()) {
 	}
 }
 
+// doRequest sends a request with the token to the test server. String and
+// byte slice bodies are sent as is, other non-nil bodies are encoded as JSON.
+// The body of the response is read in full, so callers need not close it
+func doRequest(t *testing.T, token string, method string, url string, body interface{}, headers map[string]string) *http.Response {
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		jsonPayload, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		reader = bytes.NewReader(jsonPayload)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return res
+}
+
+// decodeBody decodes the JSON body of the response into v
+func decodeBody(t *testing.T, res *http.Response, v interface{}) {
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Errorf(err.Error())
+	}
+}
+
+func mustDecimal(s string) decimal {
+	d, err := parseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// testContext returns a context of the tenant that tutils.GenerateToken issues
+// tokens for by default
+func testContext() context.Context {
+	return withTenant(context.Background(), "test")
+}
+
+func createTestCustomer(t *testing.T) int {
+	c, err := customerModel.create(testContext(), customer{Name: "Acme Inc."})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	return c.ID
+}
+
 var endpoints = []struct {
 	verb string
 	path string
@@ -40,6 +113,42 @@ var endpoints = []struct {
 	{"GET", "/invoices"},
 	{"GET", "/invoices/1"},
 	{"POST", "/invoices"},
+	{"POST", "/invoices:bulk"},
+	{"GET", "/invoices/search?q=consulting"},
+	{"GET", "/invoices/by-number/INV-2019-000001"},
+	{"PUT", "/invoices/1"},
+	{"PATCH", "/invoices/1"},
+	{"DELETE", "/invoices/1"},
+	{"GET", "/invoices/1/lines"},
+	{"POST", "/invoices/1/lines"},
+	{"GET", "/invoices/1/lines/1"},
+	{"PUT", "/invoices/1/lines/1"},
+	{"DELETE", "/invoices/1/lines/1"},
+	{"POST", "/invoices/1/issue"},
+	{"POST", "/invoices/1/pay"},
+	{"POST", "/invoices/1/void"},
+	{"POST", "/invoices/1/restore"},
+	{"GET", "/invoices/1/history"},
+	{"GET", "/invoices/1/payments"},
+	{"POST", "/invoices/1/payments"},
+	{"GET", "/invoices/1/credit-notes"},
+	{"POST", "/invoices/1/credit-notes"},
+	{"GET", "/credit-notes/1"},
+	{"GET", "/tax-rates"},
+	{"PUT", "/tax-rates/NO/standard"},
+	{"GET", "/schedules"},
+	{"POST", "/schedules"},
+	{"GET", "/schedules/1"},
+	{"DELETE", "/schedules/1"},
+	{"GET", "/schedules/1/preview"},
+	{"POST", "/schedules/1/pause"},
+	{"POST", "/schedules/1/resume"},
+	{"GET", "/customers"},
+	{"POST", "/customers"},
+	{"GET", "/customers/1"},
+	{"PUT", "/customers/1"},
+	{"DELETE", "/customers/1"},
+	{"GET", "/customers/1/invoices"},
 }
 
 func TestEndpoints_WithoutToken(t *testing.T) {
@@ -65,17 +174,36 @@ func TestEndpoints_WithoutToken(t *testing.T) {
 			}
 		})
 
-		t.Run(fmt.Sprintf("%v %v: Returns error message content", x.verb, x.path), func(t *testing.T) {
+		t.Run(fmt.Sprintf("%v %v: Has Content-Type application/problem+json", x.verb, x.path), func(t *testing.T) {
+			contentType := res.Header.Get("Content-Type")
+
+			if strings.Index(contentType, "application/problem+json") != 0 {
+				t.Errorf("Content-Type should be application/problem+json")
+			}
+		})
+
+		t.Run(fmt.Sprintf("%v %v: Returns problem details", x.verb, x.path), func(t *testing.T) {
 			body, err := ioutil.ReadAll(res.Body)
 			defer res.Body.Close()
 			if err != nil {
 				t.Errorf(err.Error())
 			}
 
-			expected := "Missing Authorization header\n"
+			var result problem
+			if err := json.Unmarshal(body, &result); err != nil {
+				t.Errorf(err.Error())
+			}
+
+			expected := "Missing Authorization header"
 
-			if string(body) != expected {
-				t.Errorf("Expected error message: %q, but got %q", expected, string(body))
+			if result.Detail != expected {
+				t.Errorf("Expected error message: %q, but got %q", expected, result.Detail)
+			}
+			if result.Status != 401 || result.Title != "Unauthorized" {
+				t.Errorf("Expected status 401 and title Unauthorized, got %v and %q", result.Status, result.Title)
+			}
+			if result.CorrelationID == "" || result.CorrelationID != res.Header.Get("X-Correlation-ID") {
+				t.Errorf("Problem should include the correlation ID")
 			}
 		})
 
@@ -106,17 +234,82 @@ func TestEndpoints_WithInvalidToken(t *testing.T) {
 			}
 		})
 
-		t.Run(fmt.Sprintf("%v %v: Returns error message content", x.verb, x.path), func(t *testing.T) {
+		t.Run(fmt.Sprintf("%v %v: Has Content-Type application/problem+json", x.verb, x.path), func(t *testing.T) {
+			contentType := res.Header.Get("Content-Type")
+
+			if strings.Index(contentType, "application/problem+json") != 0 {
+				t.Errorf("Content-Type should be application/problem+json")
+			}
+		})
+
+		t.Run(fmt.Sprintf("%v %v: Returns problem details", x.verb, x.path), func(t *testing.T) {
 			body, err := ioutil.ReadAll(res.Body)
 			defer res.Body.Close()
 			if err != nil {
 				t.Errorf(err.Error())
 			}
 
-			expected := "Invalid JWT token\n"
+			var result problem
+			if err := json.Unmarshal(body, &result); err != nil {
+				t.Errorf(err.Error())
+			}
+
+			expected := "Invalid JWT token"
+
+			if result.Detail != expected {
+				t.Errorf("Expected error message: %q, but got %q", expected, result.Detail)
+			}
+			if result.Status != 401 || result.Title != "Unauthorized" {
+				t.Errorf("Expected status 401 and title Unauthorized, got %v and %q", result.Status, result.Title)
+			}
+			if result.CorrelationID == "" || result.CorrelationID != res.Header.Get("X-Correlation-ID") {
+				t.Errorf("Problem should include the correlation ID")
+			}
+		})
+	}
+}
+
+func TestEndpoints_WithoutPermission(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	tests := []struct {
+		verb   string
+		path   string
+		revoke func(c *tutils.InvoicesClaims)
+	}{
+		{"GET", "/invoices?includeDeleted=true", func(c *tutils.InvoicesClaims) { c.GetDeletedInvoices = false }},
+		{"GET", "/invoices/1?includeDeleted=true", func(c *tutils.InvoicesClaims) { c.GetDeletedInvoices = false }},
+		{"GET", "/customers/1/invoices?includeDeleted=true", func(c *tutils.InvoicesClaims) { c.GetDeletedInvoices = false }},
+		{"POST", "/invoices/1/restore", func(c *tutils.InvoicesClaims) { c.RestoreInvoice = false }},
+		{"GET", "/invoices/1/history", func(c *tutils.InvoicesClaims) { c.GetInvoiceHistory = false }},
+		{"POST", "/invoices/1/payments", func(c *tutils.InvoicesClaims) { c.CreatePayment = false }},
+		{"POST", "/invoices/1/credit-notes", func(c *tutils.InvoicesClaims) { c.CreateCreditNote = false }},
+		{"PUT", "/tax-rates/NO/standard", func(c *tutils.InvoicesClaims) { c.UpdateTaxRates = false }},
+		{"GET", "/schedules", func(c *tutils.InvoicesClaims) { c.GetSchedules = false }},
+		{"GET", "/schedules/1", func(c *tutils.InvoicesClaims) { c.GetSchedules = false }},
+		{"GET", "/schedules/1/preview", func(c *tutils.InvoicesClaims) { c.GetSchedules = false }},
+		{"POST", "/schedules", func(c *tutils.InvoicesClaims) { c.UpdateSchedules = false }},
+		{"DELETE", "/schedules/1", func(c *tutils.InvoicesClaims) { c.UpdateSchedules = false }},
+		{"POST", "/schedules/1/pause", func(c *tutils.InvoicesClaims) { c.UpdateSchedules = false }},
+		{"POST", "/schedules/1/resume", func(c *tutils.InvoicesClaims) { c.UpdateSchedules = false }},
+	}
+
+	for _, x := range tests {
+		claims := tutils.AllClaims("")
+		x.revoke(&claims)
+		token := tutils.GenerateToken(config.jwt.secret, claims)
+
+		t.Run(fmt.Sprintf("%v %v: Responds with 403", x.verb, x.path), func(t *testing.T) {
+			res := doRequest(t, token, x.verb, ts.URL+x.path, nil, nil)
+			if res.StatusCode != 403 {
+				t.Errorf("Should return status code %v. Returned code was: %v", 403, res.StatusCode)
+			}
 
-			if string(body) != expected {
-				t.Errorf("Expected error message: %q, but got %q", expected, string(body))
+			var result problem
+			decodeBody(t, res, &result)
+			if result.Detail != "Operation not permitted" {
+				t.Errorf("Expected error message: %q, but got %q", "Operation not permitted", result.Detail)
 			}
 		})
 	}
@@ -159,19 +352,25 @@ func TestCreateInvoice(t *testing.T) {
 	ts, teardown := setup()
 	defer teardown()
 
-	customerID := 24002
+	customerID := createTestCustomer(t)
 	description := "Office supplies, and other fascinating items"
 	dueDate, err := time.Parse(time.RFC3339, "2019-10-23T00:00:00Z")
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	amount := 1024.12
+	amount := mustDecimal("1024.12")
 
 	expected := invoice{
-		CustomerID:  customerID,
-		Description: description,
-		DueDate:     dueDate,
-		Amount:      amount,
+		CustomerID:   customerID,
+		Description:  description,
+		DueDate:      dueDate,
+		TaxMode:      taxExclusive,
+		Jurisdiction: config.tax.jurisdiction,
+		NetAmount:    amount,
+		Amount:       amount,
+		Balance:      amount,
+		Currency:     "NOK",
+		Status:       statusDraft,
 	}
 
 	jsonPayload, err := json.Marshal(expected)
@@ -183,7 +382,7 @@ func TestCreateInvoice(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
-	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true}))
+	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{CreateInvoice: true}))
 
 	client := &http.Client{}
 	res, err := client.Do(req)
@@ -248,6 +447,7 @@ func TestCreateInvoice(t *testing.T) {
 		}
 
 		expected.ID = result.ID // We don't know the ID before it has been created
+		expected.Number = fmt.Sprintf("INV-%d-000001", time.Now().UTC().Year())
 		if !reflect.DeepEqual(result, expected) {
 			t.Errorf("API should create invoice with provided values")
 		}
@@ -259,13 +459,14 @@ func TestGetInvoice(t *testing.T) {
 	ts, teardown := setup()
 	defer teardown()
 
-	ctx := context.Background()
+	ctx := testContext()
+	customerID := createTestCustomer(t)
 
-	_, err := model.create(ctx, invoice{CustomerID: 0, Description: "First invoice", DueDate: time.Now(), Amount: 123.43})
+	_, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
-	expected, err := model.create(ctx, invoice{CustomerID: 0, Description: "Another invoice", DueDate: time.Now(), Amount: 1})
+	expected, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Another invoice", DueDate: time.Now(), Amount: mustDecimal("1"), Currency: "NOK"})
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -275,7 +476,7 @@ func TestGetInvoice(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 
-	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true}))
+	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoice: true}))
 
 	client := &http.Client{}
 	res, err := client.Do(req)
@@ -351,8 +552,9 @@ func TestGetInvoices(t *testing.T) {
 	ts, teardown := setup()
 	defer teardown()
 
-	ctx := context.Background()
-	model.create(ctx, invoice{CustomerID: 0, Description: "First invoice", DueDate: time.Now(), Amount: 123.43})
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
 
 	req, err := http.NewRequest("GET", ts.URL+"/invoices", nil)
 	if err != nil {
@@ -418,14 +620,2095 @@ func TestGetInvoices(t *testing.T) {
 			t.Errorf(err.Error())
 		}
 
-		var invoices []invoice
+		var page invoicePage
 
-		if err := json.Unmarshal(body, &invoices); err != nil {
+		if err := json.Unmarshal(body, &page); err != nil {
 			t.Errorf(err.Error())
 		}
 
-		if len(invoices) == 0 {
+		if len(page.Invoices) == 0 {
 			t.Error("API should return Invoices")
 		}
 	})
 }
+
+func TestGetInvoices_Pagination(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	for n := 0; n < 3; n++ {
+		model.create(ctx, invoice{CustomerID: customerID, Description: fmt.Sprintf("Invoice %v", n), DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true})
+	getPage := func(url string) (*http.Response, invoicePage) {
+		res := doRequest(t, token, "GET", url, nil, nil)
+		var page invoicePage
+		if res.StatusCode == 200 {
+			decodeBody(t, res, &page)
+		}
+		return res, page
+	}
+
+	res, first := getPage(ts.URL + "/invoices?limit=2")
+
+	t.Run("Returns at most limit invoices", func(t *testing.T) {
+		if len(first.Invoices) != 2 {
+			t.Errorf("Expected 2 invoices, got %v", len(first.Invoices))
+		}
+	})
+
+	t.Run("Returns next cursor and Link header", func(t *testing.T) {
+		if first.NextCursor == "" {
+			t.Errorf("Missing nextCursor")
+		}
+		if !strings.Contains(res.Header.Get("Link"), `rel="next"`) {
+			t.Errorf("Missing Link header with rel=next")
+		}
+	})
+
+	_, second := getPage(ts.URL + "/invoices?limit=2&cursor=" + first.NextCursor)
+
+	t.Run("Continues after the cursor", func(t *testing.T) {
+		if len(second.Invoices) != 1 || second.Invoices[0].ID <= first.Invoices[1].ID {
+			t.Errorf("Expected the remaining invoice after the cursor")
+		}
+		if second.NextCursor != "" {
+			t.Errorf("Last page should not have a nextCursor")
+		}
+	})
+
+	t.Run("Rejects invalid cursor with 400", func(t *testing.T) {
+		res, _ := getPage(ts.URL + "/invoices?cursor=not-a-cursor")
+		if res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+	})
+}
+
+func TestUpdateInvoice(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	otherCustomerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	dueDate, err := time.Parse(time.RFC3339, "2019-11-23T00:00:00Z")
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	expected := invoice{
+		ID:           created.ID,
+		Number:       created.Number,
+		CustomerID:   otherCustomerID,
+		Description:  "Updated invoice",
+		DueDate:      dueDate,
+		TaxMode:      taxExclusive,
+		Jurisdiction: config.tax.jurisdiction,
+		NetAmount:    mustDecimal("99.5"),
+		Amount:       mustDecimal("99.5"),
+		Balance:      mustDecimal("99.5"),
+		Currency:     "NOK",
+		Status:       statusDraft,
+	}
+
+	jsonPayload, err := json.Marshal(expected)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{UpdateInvoice: true}))
+	req.Header.Add("If-Match", invoiceETag(created))
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	t.Run("Responds with 200", func(t *testing.T) {
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v", 200)
+		}
+	})
+
+	t.Run("Returns updated invoice", func(t *testing.T) {
+		body, err := ioutil.ReadAll(res.Body)
+		defer res.Body.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		var result invoice
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("API should replace invoice with provided values")
+		}
+	})
+}
+
+func TestPatchInvoice(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID),
+		strings.NewReader(`{"amount": 200, "description": null}`))
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{UpdateInvoice: true}))
+	req.Header.Add("If-Match", invoiceETag(created))
+	req.Header.Add("Content-Type", "application/merge-patch+json")
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	t.Run("Responds with 200", func(t *testing.T) {
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v", 200)
+		}
+	})
+
+	t.Run("Merges patch into invoice", func(t *testing.T) {
+		body, err := ioutil.ReadAll(res.Body)
+		defer res.Body.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+
+		var result invoice
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if result.Amount != mustDecimal("200") {
+			t.Errorf("API should replace members present in the patch")
+		}
+		if result.Description != "" {
+			t.Errorf("API should remove members set to null in the patch")
+		}
+		if result.CustomerID != created.CustomerID {
+			t.Errorf("API should keep members not present in the patch")
+		}
+	})
+}
+
+func TestDeleteInvoice(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	req.Header.Add("Authorization", "Bearer "+tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{DeleteInvoice: true}))
+	req.Header.Add("If-Match", invoiceETag(created))
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	t.Run("Responds with 204", func(t *testing.T) {
+		if res.StatusCode != 204 {
+			t.Errorf("Should return status code %v", 204)
+		}
+	})
+
+	t.Run("Removes invoice", func(t *testing.T) {
+		if _, err := model.getByID(ctx, created.ID); err == nil {
+			t.Errorf("Invoice should no longer exist")
+		}
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Deleted invoice", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	res := doRequest(t, token, "DELETE", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, map[string]string{"If-Match": invoiceETag(created)})
+	if res.StatusCode != 204 {
+		t.Fatalf("Should return status code %v. Returned code was: %v", 204, res.StatusCode)
+	}
+
+	t.Run("Deleted invoice is hidden", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, nil)
+		if res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+	})
+
+	t.Run("Deleted invoice is returned with includeDeleted", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v?includeDeleted=true", ts.URL, created.ID), nil, nil)
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		var result invoice
+		json.NewDecoder(res.Body).Decode(&result)
+		if result.DeletedAt == nil {
+			t.Errorf("Should have deletedAt set")
+		}
+	})
+
+	t.Run("Restores deleted invoice", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/restore", ts.URL, created.ID), nil, nil)
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		if _, err := model.getByID(ctx, created.ID); err != nil {
+			t.Errorf("Invoice should exist after restore: %v", err)
+		}
+	})
+
+	t.Run("Restoring an invoice that is not deleted returns 409", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/restore", ts.URL, created.ID), nil, nil)
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+}
+
+func TestGetInvoices_FilterAndSort(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	otherCustomerID := createTestCustomer(t)
+	model.create(ctx, invoice{CustomerID: customerID, Description: "Office supplies", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	model.create(ctx, invoice{CustomerID: otherCustomerID, Description: "Office chairs", DueDate: time.Now(), Amount: mustDecimal("30"), Currency: "NOK"})
+	model.create(ctx, invoice{CustomerID: otherCustomerID, Description: "Coffee", DueDate: time.Now(), Amount: mustDecimal("20"), Currency: "NOK"})
+	model.create(ctx, invoice{CustomerID: otherCustomerID, Description: "Office desks", DueDate: time.Now(), Amount: mustDecimal("50"), Currency: "NOK"})
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true})
+
+	t.Run("Filters and sorts invoices", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices?customerID=%v&description=office&amountTo=40&sort=-amount", ts.URL, otherCustomerID), nil, nil)
+		defer res.Body.Close()
+
+		var page invoicePage
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+			t.Errorf(err.Error())
+		}
+
+		if len(page.Invoices) != 1 || page.Invoices[0].Description != "Office chairs" {
+			t.Errorf("API should only return invoices matching all filters")
+		}
+	})
+
+	t.Run("Paginates in sort order", func(t *testing.T) {
+		res := doRequest(t, token, "GET", ts.URL+"/invoices?sort=-amount&limit=2", nil, nil)
+		var first invoicePage
+		json.NewDecoder(res.Body).Decode(&first)
+		res.Body.Close()
+
+		res = doRequest(t, token, "GET", ts.URL+"/invoices?sort=-amount&limit=2&cursor="+first.NextCursor, nil, nil)
+		var second invoicePage
+		json.NewDecoder(res.Body).Decode(&second)
+		res.Body.Close()
+
+		amounts := []string{}
+		for _, i := range append(first.Invoices, second.Invoices...) {
+			amounts = append(amounts, i.Amount.String())
+		}
+		if !reflect.DeepEqual(amounts, []string{"50", "30", "20", "10"}) {
+			t.Errorf("Expected amounts in descending order across pages, got %v", amounts)
+		}
+	})
+
+	t.Run("Pages through invoices without a due date", func(t *testing.T) {
+		undatedCustomerID := createTestCustomer(t)
+		evening := time.Date(2019, 11, 1, 23, 0, 0, 0, time.UTC)
+		model.create(ctx, invoice{CustomerID: undatedCustomerID, Description: "Undated", Amount: mustDecimal("1"), Currency: "NOK"})
+		model.create(ctx, invoice{CustomerID: undatedCustomerID, Description: "Dated", DueDate: evening, Amount: mustDecimal("1"), Currency: "NOK"})
+		model.create(ctx, invoice{CustomerID: undatedCustomerID, Description: "Undated", Amount: mustDecimal("1"), Currency: "NOK"})
+
+		for _, sort := range []string{"dueDate", "-dueDate"} {
+			descriptions := []string{}
+			url := fmt.Sprintf("%v/invoices?customerID=%v&sort=%v&limit=1", ts.URL, undatedCustomerID, sort)
+			for next := url; next != ""; {
+				var page invoicePage
+				decodeBody(t, doRequest(t, token, "GET", next, nil, nil), &page)
+				for _, i := range page.Invoices {
+					descriptions = append(descriptions, i.Description)
+				}
+				next = ""
+				if page.NextCursor != "" {
+					next = url + "&cursor=" + page.NextCursor
+				}
+			}
+			if len(descriptions) != 3 {
+				t.Errorf("sort=%v should page through every invoice, got %v", sort, descriptions)
+			}
+		}
+
+		var page invoicePage
+		decodeBody(t, doRequest(t, token, "GET", fmt.Sprintf("%v/invoices?customerID=%v&dueDateTo=2019-11-01", ts.URL, undatedCustomerID), nil, nil), &page)
+		if len(page.Invoices) != 1 || page.Invoices[0].Description != "Dated" {
+			t.Errorf("dueDateTo should include invoices due later that day, got %+v", page.Invoices)
+		}
+	})
+
+	t.Run("Responds with 400 listing invalid fields", func(t *testing.T) {
+		res := doRequest(t, token, "GET", ts.URL+"/invoices?customerID=abc&sort=-colour&foo=bar", nil, nil)
+		defer res.Body.Close()
+
+		if res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+
+		var result struct {
+			InvalidFields []string `json:"invalidFields"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Errorf(err.Error())
+		}
+
+		expected := []string{"customerID", "foo", "-colour"}
+		if !reflect.DeepEqual(result.InvalidFields, expected) {
+			t.Errorf("Expected invalid fields %v, got %v", expected, result.InvalidFields)
+		}
+	})
+}
+
+func TestInvoiceStatusTransitions(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{UpdateInvoice: true, IssueInvoice: true, PayInvoice: true, VoidInvoice: true})
+	post := func(ID int, action string) (*http.Response, invoice) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/%v", ts.URL, ID, action), nil, nil)
+		var result invoice
+		if res.StatusCode == 200 {
+			decodeBody(t, res, &result)
+		}
+		return res, result
+	}
+
+	t.Run("New invoices are drafts", func(t *testing.T) {
+		if created.Status != statusDraft {
+			t.Errorf("Expected status %v, got %v", statusDraft, created.Status)
+		}
+	})
+
+	t.Run("Issues a draft invoice", func(t *testing.T) {
+		res, result := post(created.ID, "issue")
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if result.Status != statusIssued {
+			t.Errorf("Expected status %v, got %v", statusIssued, result.Status)
+		}
+		if result.IssuedAt == nil {
+			t.Errorf("Should record when the invoice was issued")
+		}
+	})
+
+	t.Run("Responds with 409 when paying an invoice with an outstanding balance", func(t *testing.T) {
+		res, _ := post(created.ID, "pay")
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+
+	t.Run("Responds with 409 when editing an issued invoice", func(t *testing.T) {
+		issued, err := model.getByID(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued.Amount = mustDecimal("1.00")
+		res := doRequest(t, token, "PUT", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), issued, map[string]string{"If-Match": invoiceETag(issued)})
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+
+	settled, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Settled invoice", DueDate: time.Now(), Amount: mustDecimal("0"), Currency: "NOK"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := post(settled.ID, "issue"); res.StatusCode != 200 {
+		t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+	}
+
+	t.Run("Marks an issued invoice without an outstanding balance as paid", func(t *testing.T) {
+		res, result := post(settled.ID, "pay")
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if result.Status != statusPaid {
+			t.Errorf("Expected status %v, got %v", statusPaid, result.Status)
+		}
+	})
+
+	t.Run("Responds with 409 on illegal transitions", func(t *testing.T) {
+		res, _ := post(settled.ID, "void")
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+}
+
+func TestInvoiceLines(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{CreateInvoice: true, GetInvoice: true, UpdateInvoice: true})
+
+	var created invoice
+	res := doRequest(t, token, "POST", ts.URL+"/invoices", invoice{
+		CustomerID:  customerID,
+		Description: "Office supplies",
+		DueDate:     time.Now(),
+		Currency:    "NOK",
+		Lines: []invoiceLine{
+			{Description: "Paper", Quantity: mustDecimal("2"), UnitPrice: mustDecimal("100"), TaxRate: mustDecimal("0.25")},
+			{Description: "Pens", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("50")},
+		},
+	}, nil)
+	decodeBody(t, res, &created)
+
+	t.Run("Creates invoice with lines", func(t *testing.T) {
+		if len(created.Lines) != 2 {
+			t.Errorf("Expected 2 lines, got %v", len(created.Lines))
+		}
+	})
+
+	t.Run("Computes amount from lines", func(t *testing.T) {
+		if created.Amount != mustDecimal("300") {
+			t.Errorf("Expected amount %v, got %v", "300", created.Amount)
+		}
+	})
+
+	stapler := invoiceLine{Description: "Stapler", Quantity: mustDecimal("3"), UnitPrice: mustDecimal("10"), TaxRate: mustDecimal("0.25")}
+	linesURL := fmt.Sprintf("%v/invoices/%v/lines", ts.URL, created.ID)
+
+	t.Run("Responds with 428 when If-Match is missing", func(t *testing.T) {
+		if res := doRequest(t, token, "POST", linesURL, stapler, nil); res.StatusCode != 428 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 428, res.StatusCode)
+		}
+	})
+
+	// Every change of a line responds with the entity tag of the changed
+	// invoice, which must match the one returned when reading the invoice
+	checkETag := func(t *testing.T, res *http.Response) string {
+		etag := res.Header.Get("ETag")
+		current := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, nil)
+		if etag == "" || etag == invoiceETag(created) || etag != current.Header.Get("ETag") {
+			t.Errorf("Should respond with the ETag %v of the changed invoice. Returned %v", current.Header.Get("ETag"), etag)
+		}
+		return etag
+	}
+
+	var line invoiceLine
+	res = doRequest(t, token, "POST", linesURL, stapler, map[string]string{"If-Match": invoiceETag(created)})
+	decodeBody(t, res, &line)
+	etag := checkETag(t, res)
+
+	t.Run("Adds line to invoice", func(t *testing.T) {
+		if res.StatusCode != 201 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		if line.Amount != mustDecimal("37.5") {
+			t.Errorf("Expected line amount %v, got %v", "37.5", line.Amount)
+		}
+
+		var result invoice
+		decodeBody(t, doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, nil), &result)
+		if result.Amount != mustDecimal("337.5") {
+			t.Errorf("Expected amount %v, got %v", "337.5", result.Amount)
+		}
+	})
+
+	t.Run("Responds with 412 when If-Match is stale", func(t *testing.T) {
+		res := doRequest(t, token, "PUT", fmt.Sprintf("%v/%v", linesURL, line.ID), stapler, map[string]string{"If-Match": invoiceETag(created)})
+		if res.StatusCode != 412 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 412, res.StatusCode)
+		}
+	})
+
+	t.Run("Updates line of invoice", func(t *testing.T) {
+		stapler.Quantity = mustDecimal("4")
+		res := doRequest(t, token, "PUT", fmt.Sprintf("%v/%v", linesURL, line.ID), stapler, map[string]string{"If-Match": etag})
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		etag = checkETag(t, res)
+	})
+
+	t.Run("Removes line from invoice", func(t *testing.T) {
+		res := doRequest(t, token, "DELETE", fmt.Sprintf("%v/%v", linesURL, line.ID), nil, map[string]string{"If-Match": etag})
+		if res.StatusCode != 204 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 204, res.StatusCode)
+		}
+		checkETag(t, res)
+
+		var result invoice
+		decodeBody(t, doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, nil), &result)
+		if result.Amount != mustDecimal("300") {
+			t.Errorf("Expected amount %v, got %v", "300", result.Amount)
+		}
+	})
+}
+
+func TestCreateInvoice_MoneyValidation(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{CreateInvoice: true})
+
+	for _, x := range []struct {
+		payload    string
+		statusCode int
+	}{
+		{`{"customerID": %v, "amount": "10.50", "currency": "NOK"}`, 201},
+		{`{"customerID": %v, "amount": "10.505", "currency": "NOK"}`, 422},
+		{`{"customerID": %v, "amount": "1000", "currency": "JPY"}`, 201},
+		{`{"customerID": %v, "amount": "1000.5", "currency": "JPY"}`, 422},
+		{`{"customerID": %v, "amount": "10.505", "currency": "BHD"}`, 201},
+		{`{"customerID": %v, "amount": "10", "currency": "XXX"}`, 422},
+		{`{"customerID": %v, "amount": "10"}`, 422},
+	} {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", fmt.Sprintf(x.payload, customerID), nil)
+		res.Body.Close()
+
+		t.Run(fmt.Sprintf("%v: Responds with %v", x.payload, x.statusCode), func(t *testing.T) {
+			if res.StatusCode != x.statusCode {
+				t.Errorf("Should return status code %v. Returned code was: %v", x.statusCode, res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestCustomers(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{
+		CreateCustomer: true,
+		GetCustomer:    true,
+		DeleteCustomer: true,
+		CreateInvoice:  true,
+		GetInvoices:    true,
+	})
+
+	res := doRequest(t, token, "POST", ts.URL+"/customers", `{"name": "Acme Inc.", "email": "billing@acme.example"}`, nil)
+	var created customer
+	json.NewDecoder(res.Body).Decode(&created)
+	res.Body.Close()
+
+	t.Run("Creates customer", func(t *testing.T) {
+		if res.StatusCode != 201 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		if created.Name != "Acme Inc." || created.Email != "billing@acme.example" {
+			t.Errorf("API should create customer with provided values")
+		}
+	})
+
+	t.Run("Rejects invoices for unknown customers with 422", func(t *testing.T) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", fmt.Sprintf(`{"customerID": %v, "amount": "10", "currency": "NOK"}`, created.ID+1000), nil)
+		res.Body.Close()
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	res = doRequest(t, token, "POST", ts.URL+"/invoices", fmt.Sprintf(`{"customerID": %v, "amount": "10", "currency": "NOK"}`, created.ID), nil)
+	var i invoice
+	json.NewDecoder(res.Body).Decode(&i)
+	res.Body.Close()
+
+	t.Run("Lists invoices of customer", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/customers/%v/invoices", ts.URL, created.ID), nil, nil)
+		defer res.Body.Close()
+
+		var page invoicePage
+		if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+			t.Errorf(err.Error())
+		}
+		if len(page.Invoices) != 1 || page.Invoices[0].ID != i.ID {
+			t.Errorf("API should return the invoices of the customer")
+		}
+	})
+
+	t.Run("Refuses to delete customers with invoices with 409", func(t *testing.T) {
+		res := doRequest(t, token, "DELETE", fmt.Sprintf("%v/customers/%v", ts.URL, created.ID), nil, nil)
+		res.Body.Close()
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+
+	t.Run("Responds with 404 for unknown customers", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/customers/%v/invoices", ts.URL, created.ID+1000), nil, nil)
+		res.Body.Close()
+		if res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+	})
+}
+
+func TestCreateInvoice_Validation(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	payload := fmt.Sprintf(`{
+		"customerID": 0,
+		"amount": "-5",
+		"currency": "NOK",
+		"description": %q,
+		"lines": [{"description": "", "quantity": "1", "unitPrice": "10"}]
+	}`, strings.Repeat("x", 46))
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{CreateInvoice: true})
+	res := doRequest(t, token, "POST", ts.URL+"/invoices", payload, nil)
+
+	t.Run("Responds with 422", func(t *testing.T) {
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Returns field errors", func(t *testing.T) {
+		defer res.Body.Close()
+
+		var result struct {
+			Errors []fieldError `json:"errors"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Errorf(err.Error())
+		}
+
+		expected := []fieldError{
+			{"customerID", "required", "Value is required"},
+			{"description", "max_length", "Value must not be longer than 45 characters"},
+			{"amount", "min", "Value must not be less than 0"},
+			{"lines[0].description", "required", "Value is required"},
+		}
+		if !reflect.DeepEqual(result.Errors, expected) {
+			t.Errorf("Expected errors %v, got %v", expected, result.Errors)
+		}
+	})
+}
+
+func TestCreateInvoice_IdempotencyKey(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{CreateInvoice: true})
+	post := func(key string, payload string) (*http.Response, invoice) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", payload, map[string]string{"Idempotency-Key": key})
+		var result invoice
+		if res.StatusCode == 201 {
+			decodeBody(t, res, &result)
+		}
+		return res, result
+	}
+
+	payload := fmt.Sprintf(`{"customerID": %v, "amount": "10", "currency": "NOK"}`, customerID)
+	original, first := post("invoice-key-1", payload)
+	res, second := post("invoice-key-1", payload)
+
+	t.Run("Replays the stored response for repeated keys", func(t *testing.T) {
+		if res.StatusCode != 201 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		if res.Header.Get("Idempotent-Replayed") != "true" {
+			t.Errorf("Replayed responses should have the Idempotent-Replayed header")
+		}
+		if second.ID != first.ID {
+			t.Errorf("API should not create a second invoice")
+		}
+	})
+
+	t.Run("Replays the ETag and Location headers", func(t *testing.T) {
+		for _, name := range []string{"ETag", "Location"} {
+			if original.Header.Get(name) == "" || res.Header.Get(name) != original.Header.Get(name) {
+				t.Errorf("Should replay %v header %q. Replayed %q", name, original.Header.Get(name), res.Header.Get(name))
+			}
+		}
+	})
+
+	t.Run("Responds with 422 when the key is reused with a different body", func(t *testing.T) {
+		res, _ := post("invoice-key-1", fmt.Sprintf(`{"customerID": %v, "amount": "20", "currency": "NOK"}`, customerID))
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Responds with 422 when the key is reused with a different query", func(t *testing.T) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices?series=default", payload, map[string]string{"Idempotency-Key": "invoice-key-1"})
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Creates separate invoices for different keys", func(t *testing.T) {
+		_, third := post("invoice-key-2", payload)
+		if third.ID == first.ID {
+			t.Errorf("API should create a new invoice for a new key")
+		}
+	})
+}
+
+func TestIdempotent_ReleasesKeyOnPanic(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	calls := 0
+	handler := idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func() (res *httptest.ResponseRecorder, panicked bool) {
+		defer func() {
+			panicked = recover() != nil
+		}()
+		req := httptest.NewRequest("POST", "/invoices", strings.NewReader("{}")).WithContext(testContext())
+		req.Header.Set("Idempotency-Key", "panic-key")
+		res = httptest.NewRecorder()
+		handler(res, req)
+		return res, false
+	}
+
+	if _, panicked := send(); !panicked {
+		t.Fatalf("Handler should panic")
+	}
+	if res, _ := send(); res.Code != http.StatusCreated {
+		t.Errorf("Should retry the request after a panic. Returned code was: %v", res.Code)
+	}
+}
+
+func TestInvoiceConditionalRequests(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "First invoice", DueDate: time.Now(), Amount: mustDecimal("123.43"), Currency: "NOK"})
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+
+	url := fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoice: true, UpdateInvoice: true, DeleteInvoice: true})
+
+	res := doRequest(t, token, "GET", url, nil, nil)
+	etag := res.Header.Get("ETag")
+
+	t.Run("Returns ETag", func(t *testing.T) {
+		if etag == "" {
+			t.Errorf("Missing or empty ETag header")
+		}
+	})
+
+	t.Run("Responds with 304 when If-None-Match matches", func(t *testing.T) {
+		res := doRequest(t, token, "GET", url, nil, map[string]string{"If-None-Match": etag})
+		if res.StatusCode != 304 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 304, res.StatusCode)
+		}
+	})
+
+	t.Run("Responds with 428 when If-Match is missing", func(t *testing.T) {
+		res := doRequest(t, token, "PATCH", url, `{"description": "Changed"}`, nil)
+		if res.StatusCode != 428 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 428, res.StatusCode)
+		}
+	})
+
+	res = doRequest(t, token, "PATCH", url, `{"description": "Changed"}`, map[string]string{"If-Match": etag})
+
+	t.Run("Updates when If-Match matches", func(t *testing.T) {
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if res.Header.Get("ETag") == etag {
+			t.Errorf("ETag should change when the invoice is updated")
+		}
+	})
+
+	t.Run("Responds with 412 when If-Match is stale", func(t *testing.T) {
+		res := doRequest(t, token, "DELETE", url, nil, map[string]string{"If-Match": etag})
+		if res.StatusCode != 412 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 412, res.StatusCode)
+		}
+	})
+}
+
+func TestInvoiceHistory(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Audited invoice", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	update := created
+	update.Description = "Audited invoice, updated"
+	res := doRequest(t, token, "PUT", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), update, map[string]string{
+		"If-Match":         invoiceETag(created),
+		"X-Correlation-ID": "history-test",
+	})
+	if res.StatusCode != 200 {
+		t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+	}
+
+	res = doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v/history", ts.URL, created.ID), nil, nil)
+	if res.StatusCode != 200 {
+		t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+	}
+
+	var events []auditEvent
+	if err := json.NewDecoder(res.Body).Decode(&events); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	t.Run("Records every mutation", func(t *testing.T) {
+		if len(events) != 2 {
+			t.Fatalf("Should return %v events. Returned %v", 2, len(events))
+		}
+		if events[0].Action != actionCreate || events[1].Action != actionUpdate {
+			t.Errorf("Should return events %q and %q. Returned %q and %q", actionCreate, actionUpdate, events[0].Action, events[1].Action)
+		}
+	})
+
+	t.Run("Records actor and correlation ID", func(t *testing.T) {
+		if len(events) != 2 {
+			t.Skip()
+		}
+		if events[1].Actor != "test" {
+			t.Errorf("Should record actor %q. Recorded %q", "test", events[1].Actor)
+		}
+		if events[1].CorrelationID != "history-test" {
+			t.Errorf("Should record correlation ID %q. Recorded %q", "history-test", events[1].CorrelationID)
+		}
+	})
+
+	t.Run("Records state before and after", func(t *testing.T) {
+		if len(events) != 2 {
+			t.Skip()
+		}
+		if events[0].Before != nil {
+			t.Errorf("Should have no state before creation")
+		}
+
+		var before, after invoice
+		json.Unmarshal(events[1].Before, &before)
+		json.Unmarshal(events[1].After, &after)
+		if before.Description != created.Description || after.Description != update.Description {
+			t.Errorf("Should record description change from %q to %q. Recorded %q to %q", created.Description, update.Description, before.Description, after.Description)
+		}
+	})
+
+	t.Run("Unknown invoice returns 404", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v/history", ts.URL, 999999), nil, nil)
+		if res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+	})
+}
+
+func TestBulkCreateInvoices(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	t.Run("Imports valid CSV rows and reports failed rows", func(t *testing.T) {
+		body := fmt.Sprintf("customerID,description,dueDate,amount,currency\n"+
+			"%[1]v,First,2019-11-23,10.50,NOK\n"+
+			"%[1]v,Second,not a date,10,NOK\n"+
+			"999999,Third,2019-11-23,10,NOK\n"+
+			"%[1]v,Fourth,,20,EUR\n", customerID)
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk", body, map[string]string{"Content-Type": "text/csv"})
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		var report bulkReport
+		json.NewDecoder(res.Body).Decode(&report)
+		if report.Created != 2 || report.Failed != 2 {
+			t.Fatalf("Should create %v and fail %v rows. Created %v and failed %v", 2, 2, report.Created, report.Failed)
+		}
+
+		expected := []string{bulkCreated, bulkFailed, bulkFailed, bulkCreated}
+		for n, result := range report.Results {
+			if result.Row != n+1 || result.Status != expected[n] {
+				t.Errorf("Row %v should be %q. Returned row %v as %q", n+1, expected[n], result.Row, result.Status)
+			}
+		}
+		if _, err := model.getByID(testContext(), report.Results[0].ID); err != nil {
+			t.Errorf("Imported invoice should exist: %v", err)
+		}
+	})
+
+	t.Run("Atomic NDJSON import creates nothing when a row fails", func(t *testing.T) {
+		otherCustomerID := createTestCustomer(t)
+		body := fmt.Sprintf(`{"customerID": %[1]v, "amount": "10", "currency": "NOK"}
+{"customerID": %[1]v, "amount": "-10", "currency": "NOK"}
+
+{"customerID": %[1]v, "amount": "30", "currency": "NOK"}
+`, otherCustomerID)
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk?atomic=true", body, map[string]string{"Content-Type": "application/x-ndjson"})
+		if res.StatusCode != 422 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+
+		var report bulkReport
+		json.NewDecoder(res.Body).Decode(&report)
+		if report.Created != 0 || report.Failed != 1 || len(report.Results) != 3 {
+			t.Errorf("Should report 3 rows with 1 failed and none created. Returned %+v", report)
+		}
+
+		var page invoicePage
+		decodeBody(t, doRequest(t, token, "GET", fmt.Sprintf("%v/customers/%v/invoices", ts.URL, otherCustomerID), nil, nil), &page)
+		if len(page.Invoices) != 0 {
+			t.Errorf("Should not create any invoices. Created %v", len(page.Invoices))
+		}
+	})
+
+	t.Run("Fails rows whose lines exceed the maximum amount without affecting the others", func(t *testing.T) {
+		otherCustomerID := createTestCustomer(t)
+		body := fmt.Sprintf(`{"customerID": %[1]v, "currency": "NOK", "lines": [{"description": "Servers", "quantity": "1", "unitPrice": "99999999", "taxRate": "0.25"}]}
+{"customerID": %[1]v, "currency": "NOK", "lines": [{"description": "Servers", "quantity": "1", "unitPrice": "99999999", "category": "standard"}]}
+{"customerID": %[1]v, "amount": "30", "currency": "NOK"}
+`, otherCustomerID)
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk", body, map[string]string{"Content-Type": "application/x-ndjson"})
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		var report bulkReport
+		json.NewDecoder(res.Body).Decode(&report)
+		expected := []string{bulkFailed, bulkFailed, bulkCreated}
+		if len(report.Results) != len(expected) {
+			t.Fatalf("Should report %v rows. Returned %+v", len(expected), report)
+		}
+		for n, result := range report.Results {
+			if result.Status != expected[n] {
+				t.Errorf("Row %v should be %q. Returned %q", n+1, expected[n], result.Status)
+			}
+			if result.Status == bulkFailed && (len(result.Errors) != 1 || result.Errors[0].Field != "amount") {
+				t.Errorf("Row %v should fail on the amount. Returned %+v", n+1, result.Errors)
+			}
+		}
+
+		var page invoicePage
+		decodeBody(t, doRequest(t, token, "GET", fmt.Sprintf("%v/customers/%v/invoices", ts.URL, otherCustomerID), nil, nil), &page)
+		if len(page.Invoices) != 1 || page.Invoices[0].ID != report.Results[2].ID {
+			t.Errorf("Should only create the valid row. Created %+v", page.Invoices)
+		}
+	})
+
+	t.Run("Body over the size limit returns 413", func(t *testing.T) {
+		maxBodySize := config.bulk.maxBodySize
+		config.bulk.maxBodySize = 64
+		defer func() { config.bulk.maxBodySize = maxBodySize }()
+
+		body := "customerID,description,dueDate,amount,currency\n" + strings.Repeat(fmt.Sprintf("%v,Row,2019-11-23,10,NOK\n", customerID), 10)
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk?atomic=true", body, map[string]string{"Content-Type": "text/csv"})
+		if res.StatusCode != 413 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 413, res.StatusCode)
+		}
+	})
+
+	t.Run("Unknown CSV column returns 400", func(t *testing.T) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk", "customerID,colour\n1,blue\n", map[string]string{"Content-Type": "text/csv"})
+		if res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+	})
+
+	t.Run("Unsupported content type returns 415", func(t *testing.T) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices:bulk", "[]", map[string]string{"Content-Type": "application/json"})
+		if res.StatusCode != 415 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 415, res.StatusCode)
+		}
+	})
+}
+
+func TestGetInvoices_Export(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	otherCustomerID := createTestCustomer(t)
+	for n := 0; n < 3; n++ {
+		model.create(ctx, invoice{CustomerID: customerID, Description: fmt.Sprintf("Invoice %v", n), DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	}
+	model.create(ctx, invoice{CustomerID: otherCustomerID, Description: "Other", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+
+	url := fmt.Sprintf("%v/invoices?customerID=%v&limit=1", ts.URL, customerID)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true})
+
+	t.Run("Exports all matching invoices as CSV", func(t *testing.T) {
+		res := doRequest(t, token, "GET", url, nil, map[string]string{"Accept": "text/csv"})
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/csv") {
+			t.Errorf("Should return Content-Type text/csv. Returned %q", res.Header.Get("Content-Type"))
+		}
+
+		records, err := csv.NewReader(res.Body).ReadAll()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(records) != 4 {
+			t.Errorf("Should return a header and %v rows. Returned %v records", 3, len(records))
+		}
+	})
+
+	t.Run("Exports all matching invoices as NDJSON", func(t *testing.T) {
+		res := doRequest(t, token, "GET", url, nil, map[string]string{"Accept": "application/x-ndjson"})
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		decoder := json.NewDecoder(res.Body)
+		count := 0
+		for decoder.More() {
+			var i invoice
+			if err := decoder.Decode(&i); err != nil {
+				t.Fatalf(err.Error())
+			}
+			if i.CustomerID != customerID {
+				t.Errorf("Should only export invoices of customer %v. Exported %v", customerID, i.CustomerID)
+			}
+			count++
+		}
+		if count != 3 {
+			t.Errorf("Should return %v invoices. Returned %v", 3, count)
+		}
+	})
+
+	t.Run("Unsupported media type returns 406", func(t *testing.T) {
+		res := doRequest(t, token, "GET", url, nil, map[string]string{"Accept": "application/pdf"})
+		if res.StatusCode != 406 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 406, res.StatusCode)
+		}
+	})
+}
+
+func TestGetInvoice_PDF(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Printable invoice", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoice: true})
+	res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, map[string]string{"Accept": "application/pdf"})
+
+	t.Run("Responds with 200", func(t *testing.T) {
+		if res.StatusCode != 200 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+	})
+
+	t.Run("Returns PDF document", func(t *testing.T) {
+		if res.Header.Get("Content-Type") != "application/pdf" {
+			t.Errorf("Should return Content-Type application/pdf. Returned %q", res.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		if !bytes.HasPrefix(body, []byte("%PDF-")) || !bytes.Contains(body, []byte("(Printable invoice)")) {
+			t.Errorf("Should return the invoice as PDF")
+		}
+	})
+}
+
+func TestGetInvoice_UBL(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "E-invoice", DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoice: true})
+	res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), nil, map[string]string{"Accept": "application/xml"})
+	if res.StatusCode != 200 {
+		t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+	}
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if errs := checkUBL(body); len(errs) > 0 {
+		t.Errorf("Should return a valid UBL invoice: %v", errs)
+	}
+}
+
+func TestMarkOverdue(t *testing.T) {
+	_, teardown := setup()
+	defer teardown()
+
+	ctx := withActor(testContext(), systemActor)
+	now := time.Now().UTC()
+	customerID := createTestCustomer(t)
+	create := func(dueDate time.Time, issue bool) invoice {
+		created, err := model.create(ctx, invoice{CustomerID: customerID, DueDate: dueDate, Amount: mustDecimal("10"), Currency: "NOK"})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if issue {
+			if created, err = model.transition(ctx, created.ID, statusIssued); err != nil {
+				t.Fatalf(err.Error())
+			}
+		}
+		return created
+	}
+	pastDue := create(now.AddDate(0, 0, -1), true)
+	notDue := create(now.AddDate(0, 0, 1), true)
+	draft := create(now.AddDate(0, 0, -1), false)
+
+	t.Run("Marks issued invoices past their due date as overdue", func(t *testing.T) {
+		transitioned, locked, err := model.markOverdue(ctx, now)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if !locked || transitioned != 1 {
+			t.Errorf("Should transition %v invoice. Transitioned %v, locked=%v", 1, transitioned, locked)
+		}
+
+		for _, x := range []struct {
+			invoice  invoice
+			expected invoiceStatus
+		}{
+			{pastDue, statusOverdue},
+			{notDue, statusIssued},
+			{draft, statusDraft},
+		} {
+			i, _ := model.getByID(ctx, x.invoice.ID)
+			if i.Status != x.expected {
+				t.Errorf("Invoice %v should be %v, was %v", i.ID, x.expected, i.Status)
+			}
+		}
+	})
+
+	t.Run("Records the job as actor", func(t *testing.T) {
+		events, err := model.getHistory(ctx, pastDue.ID)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if last := events[len(events)-1]; last.Actor != systemActor || last.Action != actionTransition {
+			t.Errorf("Should record %q by %q. Recorded %q by %q", actionTransition, systemActor, last.Action, last.Actor)
+		}
+	})
+
+	t.Run("Skips run while another replica holds the lock", func(t *testing.T) {
+		conn, err := model.db.Conn(ctx)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer conn.Close()
+		var acquired int
+		conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", overdueLockName).Scan(&acquired)
+		defer conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", overdueLockName).Scan(&acquired)
+
+		create(now.AddDate(0, 0, -2), true)
+		transitioned, locked, err := model.markOverdue(ctx, now)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if locked || transitioned != 0 {
+			t.Errorf("Should skip the run. Transitioned %v, locked=%v", transitioned, locked)
+		}
+	})
+}
+
+func TestInvoicePayments(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Paid in parts", DueDate: time.Now(), Amount: mustDecimal("100"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	t.Run("Draft invoice cannot receive payments", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments", ts.URL, created.ID), `{"amount": "10"}`, nil)
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+
+	if _, err := model.transition(ctx, created.ID, statusIssued); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	t.Run("Partial payment reduces balance", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments", ts.URL, created.ID), `{"amount": "40", "reference": "Bank transfer 1"}`, nil)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		i, _ := model.getByID(ctx, created.ID)
+		if i.Balance.String() != "60" || i.Status != statusIssued {
+			t.Errorf("Should have balance 60 and remain issued. Has balance %v and status %v", i.Balance, i.Status)
+		}
+	})
+
+	t.Run("Overpayment is rejected", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments", ts.URL, created.ID), `{"amount": "60.01"}`, nil)
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Invalid amounts are rejected", func(t *testing.T) {
+		for _, body := range []string{`{"amount": "0"}`, `{"amount": "-5"}`, `{"amount": "0.001"}`} {
+			if res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments", ts.URL, created.ID), body, nil); res.StatusCode != 422 {
+				t.Errorf("%v should return status code %v. Returned code was: %v", body, 422, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Paying the balance marks invoice as paid", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments", ts.URL, created.ID), `{"amount": "60"}`, nil)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		i, _ := model.getByID(ctx, created.ID)
+		if !i.Balance.isZero() || i.Status != statusPaid {
+			t.Errorf("Should have balance 0 and be paid. Has balance %v and status %v", i.Balance, i.Status)
+		}
+
+		payments, _ := model.getPayments(ctx, created.ID)
+		if len(payments) != 2 {
+			t.Errorf("Should list %v payments. Listed %v", 2, len(payments))
+		}
+	})
+
+	t.Run("Allowed overpayment leaves negative balance", func(t *testing.T) {
+		other, _ := model.create(ctx, invoice{CustomerID: customerID, DueDate: time.Now(), Amount: mustDecimal("10"), Currency: "NOK"})
+		model.transition(ctx, other.ID, statusIssued)
+		created = other
+
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/payments?allowOverpayment=true", ts.URL, created.ID), `{"amount": "15"}`, nil)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		i, _ := model.getByID(ctx, other.ID)
+		if i.Balance.String() != "-5" || i.Status != statusPaid {
+			t.Errorf("Should have balance -5 and be paid. Has balance %v and status %v", i.Balance, i.Status)
+		}
+	})
+}
+
+func TestCreditNotes(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	created, err := model.create(ctx, invoice{CustomerID: customerID, Description: "Credited invoice", DueDate: time.Now(), Amount: mustDecimal("100"), Currency: "NOK"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	t.Run("Draft invoice cannot be credited", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/credit-notes", ts.URL, created.ID), `{"amount": "10"}`, nil)
+		if res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+	})
+
+	if _, err := model.transition(ctx, created.ID, statusIssued); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	var first creditNote
+	t.Run("Credit note reduces balance", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/credit-notes", ts.URL, created.ID), `{"amount": "30", "reason": "Damaged goods"}`, nil)
+		defer res.Body.Close()
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		if err := json.NewDecoder(res.Body).Decode(&first); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if first.Number != "CN-000001" || first.InvoiceID != created.ID {
+			t.Errorf("Should return credit note CN-000001 of invoice %v. Returned %+v", created.ID, first)
+		}
+
+		i, _ := model.getByID(ctx, created.ID)
+		if i.Credited.String() != "30" || i.Balance.String() != "70" || i.Status != statusIssued {
+			t.Errorf("Should have credited 30, balance 70 and remain issued. Has credited %v, balance %v and status %v", i.Credited, i.Balance, i.Status)
+		}
+	})
+
+	t.Run("Credit notes are numbered in sequence", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/credit-notes", ts.URL, created.ID), `{"amount": "10"}`, nil)
+		defer res.Body.Close()
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		var c creditNote
+		json.NewDecoder(res.Body).Decode(&c)
+		if c.Number != "CN-000002" {
+			t.Errorf("Should be numbered %v. Was %v", "CN-000002", c.Number)
+		}
+	})
+
+	t.Run("Credit cannot exceed the invoice amount", func(t *testing.T) {
+		for _, body := range []string{`{"amount": "60.01"}`, `{"amount": "0"}`, `{"amount": "-5"}`} {
+			if res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/credit-notes", ts.URL, created.ID), body, nil); res.StatusCode != 422 {
+				t.Errorf("%v should return status code %v. Returned code was: %v", body, 422, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Crediting the balance marks invoice as paid", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices/%v/credit-notes", ts.URL, created.ID), `{"amount": "60"}`, nil)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		i, _ := model.getByID(ctx, created.ID)
+		if !i.Balance.isZero() || i.Status != statusPaid {
+			t.Errorf("Should have balance 0 and be paid. Has balance %v and status %v", i.Balance, i.Status)
+		}
+	})
+
+	t.Run("Lists and gets credit notes", func(t *testing.T) {
+		creditNotes, err := model.getCreditNotes(ctx, created.ID)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if len(creditNotes) != 3 {
+			t.Errorf("Should list %v credit notes. Listed %v", 3, len(creditNotes))
+		}
+
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/credit-notes/%v", ts.URL, first.ID), nil, nil)
+		var c creditNote
+		decodeBody(t, res, &c)
+		if res.StatusCode != 200 || c.Reason != "Damaged goods" {
+			t.Errorf("Should return the first credit note. Returned status %v and %+v", res.StatusCode, c)
+		}
+	})
+
+	t.Run("Credit notes are recorded in history", func(t *testing.T) {
+		events, err := model.getHistory(ctx, created.ID)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		count := 0
+		for _, e := range events {
+			if e.Action == actionCreditNote {
+				count++
+			}
+		}
+		if count != 3 {
+			t.Errorf("Should record %v credit note events. Recorded %v", 3, count)
+		}
+	})
+}
+
+func TestInvoiceNumbers(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	year := time.Now().UTC().Year()
+
+	config.numbering.series["export"] = numberSeries{prefix: "EX-", padding: 4}
+	defer delete(config.numbering.series, "export")
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+	create := func(query string) invoice {
+		body := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK"}`, customerID)
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices%v", ts.URL, query), body, nil)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		var i invoice
+		if err := json.NewDecoder(res.Body).Decode(&i); err != nil {
+			t.Fatalf(err.Error())
+		}
+		return i
+	}
+
+	t.Run("Numbers invoices in sequence per series", func(t *testing.T) {
+		for n, x := range []struct {
+			query    string
+			expected string
+		}{
+			{"", fmt.Sprintf("INV-%d-000001", year)},
+			{"?series=export", "EX-0001"},
+			{"?series=default", fmt.Sprintf("INV-%d-000002", year)},
+			{"?series=export", "EX-0002"},
+		} {
+			if i := create(x.query); i.Number != x.expected {
+				t.Errorf("Invoice %v should be numbered %v. Was %v", n+1, x.expected, i.Number)
+			}
+		}
+	})
+
+	t.Run("Rejects unknown series", func(t *testing.T) {
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/invoices?series=unknown", ts.URL), fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK"}`, customerID), nil)
+		if res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+	})
+
+	t.Run("Failed creates leave no gaps", func(t *testing.T) {
+		if _, err := model.create(ctx, invoice{CustomerID: customerID + 1000, Amount: mustDecimal("10"), Currency: "NOK"}); err == nil {
+			t.Fatalf("Should fail to create invoice of unknown customer")
+		}
+		if i := create(""); i.Number != fmt.Sprintf("INV-%d-000003", year) {
+			t.Errorf("Should be numbered %v. Was %v", fmt.Sprintf("INV-%d-000003", year), i.Number)
+		}
+	})
+
+	t.Run("Gets invoice by number", func(t *testing.T) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/by-number/EX-0002", ts.URL), nil, nil)
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		var i invoice
+		json.NewDecoder(res.Body).Decode(&i)
+		if i.Number != "EX-0002" {
+			t.Errorf("Should return invoice %v. Returned %v", "EX-0002", i.Number)
+		}
+
+		res = doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/by-number/EX-9999", ts.URL), nil, nil)
+		if res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+	})
+}
+
+func TestInvoiceTax(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+
+	t.Run("Computes tax exclusive of unit prices", func(t *testing.T) {
+		var created invoice
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", invoice{CustomerID: customerID, Currency: "NOK", Lines: []invoiceLine{
+			{Description: "Screw", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25")},
+			{Description: "Nut", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25")},
+			{Description: "Sandwich", Quantity: mustDecimal("2"), UnitPrice: mustDecimal("50"), Category: "food"},
+		}}, nil)
+		decodeBody(t, res, &created)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		if created.NetAmount.String() != "102.02" || created.TaxAmount.String() != "15.51" || created.Amount.String() != "117.53" {
+			t.Errorf("Expected net 102.02, tax 15.51 and amount 117.53. Got %v, %v and %v", created.NetAmount, created.TaxAmount, created.Amount)
+		}
+		if len(created.TaxSummary) != 2 || created.TaxSummary[0].Rate.String() != "0.15" || created.TaxSummary[1].Tax.String() != "0.51" {
+			t.Errorf("Should summarize tax per rate. Got %+v", created.TaxSummary)
+		}
+		if created.Lines[2].TaxRate.String() != "0.15" {
+			t.Errorf("Should take the rate of the food category in %v. Got %v", config.tax.jurisdiction, created.Lines[2].TaxRate)
+		}
+	})
+
+	t.Run("Computes tax inclusive in unit prices", func(t *testing.T) {
+		var created invoice
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", invoice{CustomerID: customerID, Currency: "NOK", TaxMode: taxInclusive, Lines: []invoiceLine{
+			{Description: "Consulting", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("125"), TaxRate: mustDecimal("0.25")},
+		}}, nil)
+		decodeBody(t, res, &created)
+
+		if created.NetAmount.String() != "100" || created.TaxAmount.String() != "25" || created.Amount.String() != "125" {
+			t.Errorf("Expected net 100, tax 25 and amount 125. Got %v, %v and %v", created.NetAmount, created.TaxAmount, created.Amount)
+		}
+		if created.Lines[0].Amount.String() != "125" {
+			t.Errorf("Line amount should equal its tax inclusive price. Got %v", created.Lines[0].Amount)
+		}
+	})
+
+	t.Run("Rejects unknown tax categories", func(t *testing.T) {
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", invoice{CustomerID: customerID, Currency: "NOK", Lines: []invoiceLine{
+			{Description: "Spaceship", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("100"), Category: "spaceships"},
+		}}, nil)
+		if res.StatusCode != 422 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Applies the rates of a new jurisdiction", func(t *testing.T) {
+		var created invoice
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", invoice{CustomerID: customerID, Currency: "EUR", Lines: []invoiceLine{
+			{Description: "Widget", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("100"), Category: "standard"},
+		}}, nil)
+		decodeBody(t, res, &created)
+
+		update := created
+		update.Jurisdiction = "DE"
+		res = doRequest(t, token, "PUT", fmt.Sprintf("%v/invoices/%v", ts.URL, created.ID), update, map[string]string{"If-Match": invoiceETag(created)})
+		var updated invoice
+		decodeBody(t, res, &updated)
+		if res.StatusCode != 200 || updated.TaxAmount.String() != "19" || updated.Amount.String() != "119" {
+			t.Errorf("Should apply the standard rate of DE. Returned status %v, tax %v and amount %v", res.StatusCode, updated.TaxAmount, updated.Amount)
+		}
+	})
+
+	t.Run("Updates tax rates", func(t *testing.T) {
+		res := doRequest(t, token, "PUT", ts.URL+"/tax-rates/NO/books", taxRate{Rate: mustDecimal("0")}, nil)
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+
+		var rates []taxRate
+		decodeBody(t, doRequest(t, token, "GET", ts.URL+"/tax-rates?jurisdiction=NO", nil, nil), &rates)
+		found := false
+		for _, r := range rates {
+			if r.Jurisdiction != "NO" {
+				t.Errorf("Should only return rates of NO. Returned %v", r.Jurisdiction)
+			}
+			found = found || r.Category == "books"
+		}
+		if !found {
+			t.Errorf("Should return the added category")
+		}
+
+		if res := doRequest(t, token, "PUT", ts.URL+"/tax-rates/NO/books", taxRate{Rate: mustDecimal("1.5")}, nil); res.StatusCode != 422 {
+			t.Errorf("Should return status code %v for rates above 100%%. Returned code was: %v", 422, res.StatusCode)
+		}
+	})
+}
+
+func TestTenantIsolation(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	customerID := createTestCustomer(t)
+	otherCustomer, err := customerModel.create(withTenant(context.Background(), "other"), customer{Name: "Other Inc."})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	year := time.Now().UTC().Year()
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+	otherToken := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims("other"))
+	create := func(token string, customerID int, header map[string]string) invoice {
+		body := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK"}`, customerID)
+		res := doRequest(t, token, "POST", ts.URL+"/invoices", body, header)
+		defer res.Body.Close()
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		var i invoice
+		if err := json.NewDecoder(res.Body).Decode(&i); err != nil {
+			t.Fatalf(err.Error())
+		}
+		return i
+	}
+
+	created := create(token, customerID, nil)
+	if _, err := model.transition(testContext(), created.ID, statusIssued); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := model.createCreditNote(testContext(), created.ID, creditNote{Amount: mustDecimal("1")}); err != nil {
+		t.Fatalf(err.Error())
+	}
+	creditNotes, _ := model.getCreditNotes(testContext(), created.ID)
+
+	t.Run("Responds with 404 to reads of other tenants", func(t *testing.T) {
+		for _, path := range []string{
+			fmt.Sprintf("/invoices/%d", created.ID),
+			fmt.Sprintf("/invoices/%d/lines", created.ID),
+			fmt.Sprintf("/invoices/%d/payments", created.ID),
+			fmt.Sprintf("/invoices/%d/credit-notes", created.ID),
+			fmt.Sprintf("/invoices/%d/history", created.ID),
+			fmt.Sprintf("/invoices/by-number/%v", created.Number),
+			fmt.Sprintf("/credit-notes/%d", creditNotes[0].ID),
+		} {
+			if res := doRequest(t, otherToken, "GET", ts.URL+path, nil, nil); res.StatusCode != 404 {
+				t.Errorf("GET %v should return status code %v. Returned code was: %v", path, 404, res.StatusCode)
+			}
+			if res := doRequest(t, token, "GET", ts.URL+path, nil, nil); res.StatusCode != 200 {
+				t.Errorf("GET %v should return status code %v to the owner. Returned code was: %v", path, 200, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Responds with 404 to changes of other tenants", func(t *testing.T) {
+		path := fmt.Sprintf("/invoices/%d", created.ID)
+		header := map[string]string{"If-Match": invoiceETag(created)}
+		body := fmt.Sprintf(`{"customerID": %d, "amount": "20", "currency": "NOK"}`, customerID)
+		for _, x := range []struct {
+			method string
+			path   string
+			body   string
+		}{
+			{"PUT", path, body},
+			{"PATCH", path, `{"description": "Taken over"}`},
+			{"DELETE", path, ""},
+			{"POST", path + "/payments", `{"amount": "1"}`},
+			{"POST", path + "/credit-notes", `{"amount": "1"}`},
+		} {
+			if res := doRequest(t, otherToken, x.method, ts.URL+x.path, x.body, header); res.StatusCode != 404 {
+				t.Errorf("%v %v should return status code %v. Returned code was: %v", x.method, x.path, 404, res.StatusCode)
+			}
+		}
+		if i, err := model.getByID(testContext(), created.ID); err != nil || i.Version != created.Version+2 {
+			t.Errorf("Invoice should be unchanged by other tenants")
+		}
+	})
+
+	t.Run("Responds with 404 to customers of other tenants", func(t *testing.T) {
+		path := fmt.Sprintf("/customers/%d", customerID)
+		for _, x := range []struct {
+			method string
+			path   string
+			body   string
+		}{
+			{"GET", path, ""},
+			{"GET", path + "/invoices", ""},
+			{"PUT", path, `{"name": "Taken over"}`},
+			{"DELETE", path, ""},
+		} {
+			if res := doRequest(t, otherToken, x.method, ts.URL+x.path, x.body, nil); res.StatusCode != 404 {
+				t.Errorf("%v %v should return status code %v. Returned code was: %v", x.method, x.path, 404, res.StatusCode)
+			}
+		}
+		if c, err := customerModel.getByID(testContext(), customerID); err != nil || c.Name != "Acme Inc." {
+			t.Errorf("Customer should be unchanged by other tenants")
+		}
+
+		var page customerPage
+		decodeBody(t, doRequest(t, otherToken, "GET", ts.URL+"/customers", nil, nil), &page)
+		if len(page.Customers) != 1 || page.Customers[0].ID != otherCustomer.ID {
+			t.Errorf("Should only list the customers of the tenant. Listed %+v", page.Customers)
+		}
+	})
+
+	t.Run("Lists only invoices of the tenant", func(t *testing.T) {
+		res := doRequest(t, otherToken, "GET", ts.URL+"/invoices", nil, nil)
+		var page invoicePage
+		json.NewDecoder(res.Body).Decode(&page)
+		if len(page.Invoices) != 0 {
+			t.Errorf("Should not list invoices of other tenants. Listed %v", len(page.Invoices))
+		}
+
+		res = doRequest(t, token, "GET", ts.URL+"/invoices", nil, nil)
+		json.NewDecoder(res.Body).Decode(&page)
+		if len(page.Invoices) != 1 || page.Invoices[0].ID != created.ID {
+			t.Errorf("Should list the invoice of the tenant")
+		}
+	})
+
+	t.Run("Numbers invoices per tenant", func(t *testing.T) {
+		if i := create(otherToken, otherCustomer.ID, nil); i.Number != created.Number {
+			t.Errorf("Should start a new sequence for the tenant at %v. Was %v", created.Number, i.Number)
+		}
+		if i := create(token, customerID, nil); i.Number != fmt.Sprintf("INV-%d-000002", year) {
+			t.Errorf("Should continue the sequence of the tenant at %v. Was %v", fmt.Sprintf("INV-%d-000002", year), i.Number)
+		}
+	})
+
+	t.Run("Scopes idempotency keys to the tenant", func(t *testing.T) {
+		header := map[string]string{"Idempotency-Key": "tenant-key"}
+		first := create(token, customerID, header)
+		second := create(otherToken, otherCustomer.ID, header)
+		if second.ID == first.ID {
+			t.Errorf("Should not replay the response of another tenant")
+		}
+	})
+
+	t.Run("Responds with 401 to tokens without a tenant", func(t *testing.T) {
+		unscoped, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"getInvoices": true,
+			"exp":         time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(config.jwt.secret))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if res := doRequest(t, unscoped, "GET", ts.URL+"/invoices", nil, nil); res.StatusCode != 401 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 401, res.StatusCode)
+		}
+	})
+
+	t.Run("Responds with 422 to invoices and schedules for customers of other tenants", func(t *testing.T) {
+		body := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK"}`, customerID)
+		if res := doRequest(t, otherToken, "POST", ts.URL+"/invoices", body, nil); res.StatusCode != 422 {
+			t.Errorf("POST /invoices should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+		schedule := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK", "every": 1, "unit": "month"}`, customerID)
+		if res := doRequest(t, otherToken, "POST", ts.URL+"/schedules", schedule, nil); res.StatusCode != 422 {
+			t.Errorf("POST /schedules should return status code %v. Returned code was: %v", 422, res.StatusCode)
+		}
+		owned := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK", "every": 1, "unit": "month"}`, otherCustomer.ID)
+		if res := doRequest(t, otherToken, "POST", ts.URL+"/schedules", owned, nil); res.StatusCode != 201 {
+			t.Errorf("POST /schedules should return status code %v for customers of the tenant. Returned code was: %v", 201, res.StatusCode)
+		}
+
+		draft := create(otherToken, otherCustomer.ID, nil)
+		update := fmt.Sprintf(`{"customerID": %d, "amount": "10", "currency": "NOK"}`, customerID)
+		res := doRequest(t, otherToken, "PUT", fmt.Sprintf("%v/invoices/%d", ts.URL, draft.ID), update, map[string]string{"If-Match": invoiceETag(draft)})
+		if res.StatusCode != 422 {
+			t.Errorf("PUT /invoices/%d should return status code %v. Returned code was: %v", draft.ID, 422, res.StatusCode)
+		}
+	})
+
+	t.Run("Scopes tax rates to the tenant", func(t *testing.T) {
+		if res := doRequest(t, token, "PUT", ts.URL+"/tax-rates/NO/standard", taxRate{Rate: mustDecimal("0.5")}, nil); res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		standardRate := func(token string) string {
+			var rates []taxRate
+			decodeBody(t, doRequest(t, token, "GET", ts.URL+"/tax-rates?jurisdiction=NO", nil, nil), &rates)
+			for _, r := range rates {
+				if r.Category == "standard" {
+					return r.Rate.String()
+				}
+			}
+			return ""
+		}
+		if rate := standardRate(token); rate != "0.5" {
+			t.Errorf("Should return the rate of the tenant. Returned %v", rate)
+		}
+		if rate := standardRate(otherToken); rate != "0.25" {
+			t.Errorf("Should return the default rate to other tenants. Returned %v", rate)
+		}
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := withActor(testContext(), systemActor)
+	customerID := createTestCustomer(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	token := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims(""))
+	create := func(s schedule) schedule {
+		var created schedule
+		res := doRequest(t, token, "POST", ts.URL+"/schedules", s, nil)
+		decodeBody(t, res, &created)
+		if res.StatusCode != 201 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 201, res.StatusCode)
+		}
+		return created
+	}
+	invoicesOf := func(s schedule) []invoice {
+		rows, err := model.db.Query("SELECT ID FROM invoices WHERE ScheduleID=? ORDER BY ScheduledFor", s.ID)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer rows.Close()
+		invoices := []invoice{}
+		for rows.Next() {
+			var ID int
+			rows.Scan(&ID)
+			i, err := model.getByID(ctx, ID)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			invoices = append(invoices, i)
+		}
+		return invoices
+	}
+	generate := func(now time.Time) int {
+		generated, locked, err := scheduleModel.generateDue(ctx, &model, now)
+		if err != nil || !locked {
+			t.Fatalf("Should generate invoices. Failed with %v, locked=%v", err, locked)
+		}
+		return generated
+	}
+
+	anchor := now.AddDate(0, 0, -15)
+	weekly := create(schedule{CustomerID: customerID, Description: "Weekly service", Amount: mustDecimal("100"), Currency: "NOK", Every: 7, Unit: unitDay, Anchor: anchor, DueDays: 14, Issue: true})
+	latest := create(schedule{CustomerID: customerID, Amount: mustDecimal("50"), Currency: "NOK", Every: 1, Unit: unitWeek, Anchor: anchor, CatchUp: catchUpLatest})
+
+	t.Run("Makes up for missed runs", func(t *testing.T) {
+		if generated := generate(now); generated != 4 {
+			t.Errorf("Should generate %v invoices. Generated %v", 4, generated)
+		}
+
+		invoices := invoicesOf(weekly)
+		if len(invoices) != 3 {
+			t.Fatalf("Should generate an invoice per missed run. Generated %v", len(invoices))
+		}
+		for n, i := range invoices {
+			dueDate := anchor.AddDate(0, 0, 7*n+14)
+			if i.Status != statusIssued || i.Amount.String() != "100" || i.Description != "Weekly service" || !i.DueDate.Equal(dueDate) {
+				t.Errorf("Invoice %v should be issued for 100 and due %v. Was %v for %v and due %v", n+1, dueDate, i.Status, i.Amount, i.DueDate)
+			}
+		}
+
+		invoices = invoicesOf(latest)
+		if len(invoices) != 1 || invoices[0].Status != statusDraft || !invoices[0].DueDate.Equal(anchor.AddDate(0, 0, 14)) {
+			t.Errorf("Should generate a draft invoice for the latest missed run only. Generated %+v", invoices)
+		}
+	})
+
+	t.Run("Creates a single invoice per run", func(t *testing.T) {
+		if generated := generate(now); generated != 0 {
+			t.Errorf("Should not repeat runs. Generated %v", generated)
+		}
+
+		// Repeat the runs, as if the generator was interrupted before
+		// advancing the schedule
+		if _, err := model.db.Exec("UPDATE schedules SET NextRunAt=? WHERE ID=?", anchor, weekly.ID); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if generated := generate(now); generated != 0 || len(invoicesOf(weekly)) != 3 {
+			t.Errorf("Should not generate invoices of runs already generated. Generated %v", generated)
+		}
+	})
+
+	t.Run("Pauses and resumes schedules", func(t *testing.T) {
+		var paused schedule
+		res := doRequest(t, token, "POST", fmt.Sprintf("%v/schedules/%d/pause", ts.URL, weekly.ID), nil, nil)
+		decodeBody(t, res, &paused)
+		if res.StatusCode != 200 || !paused.Paused {
+			t.Fatalf("Should pause the schedule. Returned status code %v", res.StatusCode)
+		}
+		if res := doRequest(t, token, "POST", fmt.Sprintf("%v/schedules/%d/pause", ts.URL, weekly.ID), nil, nil); res.StatusCode != 409 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 409, res.StatusCode)
+		}
+
+		if generate(now.AddDate(0, 0, 8)); len(invoicesOf(weekly)) != 3 {
+			t.Errorf("Should not generate invoices of paused schedules")
+		}
+
+		var resumed schedule
+		res = doRequest(t, token, "POST", fmt.Sprintf("%v/schedules/%d/resume", ts.URL, weekly.ID), nil, nil)
+		decodeBody(t, res, &resumed)
+		if res.StatusCode != 200 || resumed.Paused {
+			t.Fatalf("Should resume the schedule. Returned status code %v", res.StatusCode)
+		}
+		if !resumed.NextRunAt.Equal(anchor.AddDate(0, 0, 21)) {
+			t.Errorf("Should run next at %v. Runs at %v", anchor.AddDate(0, 0, 21), resumed.NextRunAt)
+		}
+	})
+
+	t.Run("Previews upcoming runs", func(t *testing.T) {
+		var runs []upcomingRun
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/schedules/%d/preview?count=3", ts.URL, weekly.ID), nil, nil)
+		decodeBody(t, res, &runs)
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if len(runs) != 3 {
+			t.Fatalf("Should preview %v runs. Previewed %v", 3, len(runs))
+		}
+		for n, run := range runs {
+			runAt := anchor.AddDate(0, 0, 7*(n+3))
+			if !run.RunAt.Equal(runAt) || !run.DueDate.Equal(runAt.AddDate(0, 0, 14)) {
+				t.Errorf("Run %v should be at %v. Was at %v and due %v", n+1, runAt, run.RunAt, run.DueDate)
+			}
+		}
+
+		if res := doRequest(t, token, "GET", fmt.Sprintf("%v/schedules/%d/preview?count=0", ts.URL, weekly.ID), nil, nil); res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+	})
+
+	t.Run("Runs at the times of cron expressions", func(t *testing.T) {
+		monthly := create(schedule{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK", Cron: "0 6 1 * *"})
+		if monthly.NextRunAt.Day() != 1 || monthly.NextRunAt.Hour() != 6 || monthly.NextRunAt.Before(now) {
+			t.Errorf("Should run next on the first of the month at 06:00. Runs at %v", monthly.NextRunAt)
+		}
+	})
+
+	t.Run("Validates schedules", func(t *testing.T) {
+		for _, s := range []schedule{
+			{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK"},
+			{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK", Every: 1, Unit: "fortnight"},
+			{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK", Every: 1, Unit: unitMonth, Cron: "@monthly"},
+			{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK", Cron: "0 0 30 2 *"},
+			{CustomerID: customerID, Amount: mustDecimal("10"), Currency: "NOK", Every: 1, Unit: unitMonth, CatchUp: "some"},
+			{CustomerID: customerID, Currency: "NOK", Every: 1, Unit: unitMonth, Lines: []scheduleLine{{Quantity: mustDecimal("1")}}},
+			{CustomerID: customerID + 1000, Amount: mustDecimal("10"), Currency: "NOK", Every: 1, Unit: unitMonth},
+		} {
+			if res := doRequest(t, token, "POST", ts.URL+"/schedules", s, nil); res.StatusCode != 422 {
+				t.Errorf("Should return status code %v for %+v. Returned code was: %v", 422, s, res.StatusCode)
+			}
+		}
+	})
+
+	t.Run("Isolates schedules of tenants", func(t *testing.T) {
+		otherToken := tutils.GenerateToken(config.jwt.secret, tutils.AllClaims("other"))
+		if res := doRequest(t, otherToken, "GET", fmt.Sprintf("%v/schedules/%d", ts.URL, weekly.ID), nil, nil); res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+		var page schedulePage
+		decodeBody(t, doRequest(t, otherToken, "GET", ts.URL+"/schedules", nil, nil), &page)
+		if len(page.Schedules) != 0 {
+			t.Errorf("Should not list schedules of other tenants. Listed %v", len(page.Schedules))
+		}
+	})
+
+	t.Run("Keeps invoices of deleted schedules", func(t *testing.T) {
+		invoices := invoicesOf(latest)
+		if res := doRequest(t, token, "DELETE", fmt.Sprintf("%v/schedules/%d", ts.URL, latest.ID), nil, nil); res.StatusCode != 204 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 204, res.StatusCode)
+		}
+		if res := doRequest(t, token, "GET", fmt.Sprintf("%v/schedules/%d", ts.URL, latest.ID), nil, nil); res.StatusCode != 404 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 404, res.StatusCode)
+		}
+		if _, err := model.getByID(ctx, invoices[0].ID); err != nil {
+			t.Errorf("Should keep the invoices of the schedule")
+		}
+	})
+}
+
+func TestSearchInvoices(t *testing.T) {
+	ts, teardown := setup()
+	defer teardown()
+
+	ctx := testContext()
+	customerID := createTestCustomer(t)
+	create := func(description string, lines ...string) invoice {
+		i := invoice{CustomerID: customerID, Description: description, Amount: mustDecimal("10"), Currency: "NOK"}
+		for _, l := range lines {
+			i.Lines = append(i.Lines, invoiceLine{Description: l, Quantity: mustDecimal("1"), UnitPrice: mustDecimal("10")})
+		}
+		created, err := model.create(ctx, i)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		return created
+	}
+	retainer := create("Annual consulting retainer", "Consulting hours March", "Travel expenses")
+	hardware := create("Hardware", "Server rack")
+	call := create("Consulting call")
+	create("Office supplies")
+	workshop := create("Consulting workshop")
+	if err := model.delete(ctx, workshop.ID, workshop.Version); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	token := tutils.GenerateToken(config.jwt.secret, tutils.InvoicesClaims{GetInvoices: true})
+	search := func(token string, q string) (*http.Response, searchResults) {
+		res := doRequest(t, token, "GET", fmt.Sprintf("%v/invoices/search?q=%v", ts.URL, q), nil, nil)
+		var result searchResults
+		if res.StatusCode == 200 {
+			decodeBody(t, res, &result)
+		}
+		return res, result
+	}
+
+	t.Run("Ranks invoices by relevance", func(t *testing.T) {
+		res, result := search(token, "consulting")
+		if res.StatusCode != 200 {
+			t.Fatalf("Should return status code %v. Returned code was: %v", 200, res.StatusCode)
+		}
+		if len(result.Results) != 2 || result.Results[0].Invoice.ID != retainer.ID || result.Results[1].Invoice.ID != call.ID {
+			t.Fatalf("Should find invoice %v before %v, and not the deleted invoice. Found %+v", retainer.ID, call.ID, result.Results)
+		}
+		if result.Results[0].Score <= result.Results[1].Score {
+			t.Errorf("Invoice matching in its lines too should score higher. Scored %v and %v", result.Results[0].Score, result.Results[1].Score)
+		}
+	})
+
+	t.Run("Highlights matching texts", func(t *testing.T) {
+		_, result := search(token, "consulting")
+		expected := []searchSnippet{
+			{Field: "description", Text: "Annual <mark>consulting</mark> retainer"},
+			{Field: "lines", LineID: retainer.Lines[0].ID, Text: "<mark>Consulting</mark> hours March"},
+		}
+		if len(result.Results) == 0 || !reflect.DeepEqual(result.Results[0].Snippets, expected) {
+			t.Errorf("Expected snippets %+v. Got %+v", expected, result.Results)
+		}
+
+		_, result = search(token, "server")
+		if len(result.Results) != 1 || result.Results[0].Invoice.ID != hardware.ID || result.Results[0].Snippets[0].Text != "<mark>Server</mark> rack" {
+			t.Errorf("Should find invoice %v by its line. Found %+v", hardware.ID, result.Results)
+		}
+	})
+
+	t.Run("Finds only invoices of the tenant", func(t *testing.T) {
+		_, result := search(tutils.GenerateToken(config.jwt.secret, tutils.AllClaims("other")), "consulting")
+		if len(result.Results) != 0 {
+			t.Errorf("Should not find invoices of other tenants. Found %v", len(result.Results))
+		}
+	})
+
+	t.Run("Responds with 400 without a query", func(t *testing.T) {
+		if res, _ := search(token, "%20"); res.StatusCode != 400 {
+			t.Errorf("Should return status code %v. Returned code was: %v", 400, res.StatusCode)
+		}
+	})
+}
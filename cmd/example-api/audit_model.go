@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"time"
+)
+
+const (
+	actionCreate     = "create"
+	actionUpdate     = "update"
+	actionDelete     = "delete"
+	actionRestore    = "restore"
+	actionTransition = "transition"
+	actionCreateLine = "createLine"
+	actionUpdateLine = "updateLine"
+	actionDeleteLine = "deleteLine"
+	actionPayment    = "payment"
+	actionCreditNote = "creditNote"
+)
+
+// systemActor is recorded as the actor of changes made by background jobs
+const systemActor = "system"
+
+type actorContextKey string
+
+var ctxKeyActor actorContextKey = actorContextKey("actor")
+
+// withActor returns a context recording changes as made by actor, for changes
+// not made on behalf of a request
+func withActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ctxKeyActor, actor)
+}
+
+// auditActor returns the actor put on the context by withActor, or else the
+// subject of the JWT claims put on the context by checkAuthorization
+func auditActor(ctx context.Context) string {
+	if actor, ok := ctx.Value(ctxKeyActor).(string); ok {
+		return actor
+	}
+	if claims, ok := ctx.Value(ctxKeyClaims).(jwt.MapClaims); ok {
+		if sub, ok := claims["sub"].(string); ok {
+			return sub
+		}
+	}
+	return ""
+}
+
+// auditCorrelationID returns the correlation ID put on the context by
+// ensureCorrelationID
+func auditCorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(ctxKeyCorrelationID).(string)
+	return correlationID
+}
+
+// audit records a change made to the invoice in tx. The state after the change
+// is read within tx, so the event is committed or rolled back along with the
+// change itself. before is nil when the invoice was created by the change
+func audit(ctx context.Context, tx *sql.Tx, action string, ID int, before *invoice) error {
+	after, err := queryInvoice(ctx, tx, ID, true, "")
+	if err != nil {
+		return err
+	}
+
+	var beforeJSON []byte
+	if before != nil {
+		if beforeJSON, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	var correlationID sql.NullString
+	if v := auditCorrelationID(ctx); v != "" {
+		correlationID = sql.NullString{String: v, Valid: true}
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_events (InvoiceID, Action, Actor, CorrelationID, Version, `Before`, `After`, CreatedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		ID,
+		action,
+		auditActor(ctx),
+		correlationID,
+		after.Version,
+		beforeJSON,
+		afterJSON,
+		time.Now().UTC())
+	return err
+}
+
+// getHistory returns the audit events of the invoice, oldest first. The
+// history of deleted invoices remains available
+func (model *invoicesModel) getHistory(ctx context.Context, invoiceID int) ([]auditEvent, error) {
+	if _, err := model.getByIDIncludingDeleted(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+
+	rows, err := model.db.QueryContext(ctx,
+		"SELECT ID, InvoiceID, Action, Actor, CorrelationID, Version, `Before`, `After`, CreatedAt FROM audit_events WHERE InvoiceID=? ORDER BY ID",
+		invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []auditEvent{}
+	for rows.Next() {
+		var e auditEvent
+		var correlationID sql.NullString
+		var before, after []byte
+		if err := rows.Scan(
+			&e.ID,
+			&e.InvoiceID,
+			&e.Action,
+			&e.Actor,
+			&correlationID,
+			&e.Version,
+			&before,
+			&after,
+			&e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.CorrelationID = correlationID.String
+		if before != nil {
+			e.Before = json.RawMessage(before)
+		}
+		e.After = json.RawMessage(after)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
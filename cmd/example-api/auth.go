@@ -24,7 +24,7 @@ func checkAuthorization(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authorizationHeader := r.Header.Get("Authorization")
 		if authorizationHeader == "" {
-			http.Error(w, "Missing Authorization header", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "Missing Authorization header")
 			return
 		}
 
@@ -37,31 +37,47 @@ func checkAuthorization(next http.Handler) http.Handler {
 		})
 
 		if token == nil {
-			http.Error(w, "Invalid JWT token", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "Invalid JWT token")
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			ctx := context.WithValue(r.Context(), ctxKeyClaims, claims)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		} else {
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok || !token.Valid {
 			logger.info(r, err.Error())
-			http.Error(w, "Invalid or expired JWT token", http.StatusUnauthorized)
+			writeError(w, r, http.StatusUnauthorized, "Invalid or expired JWT token")
+			return
 		}
+
+		// Every invoice belongs to a tenant, so tokens must name the tenant
+		// the caller acts for
+		tenant, ok := claims[tenantClaim].(string)
+		if !ok || !tenantPattern.MatchString(tenant) {
+			writeError(w, r, http.StatusUnauthorized, "JWT token has no valid tenant claim")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKeyClaims, claims)
+		ctx = withTenant(ctx, tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func checkPermission(handlerFunc func(w http.ResponseWriter, r *http.Request), permission string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if claims := r.Context().Value(ctxKeyClaims).(jwt.MapClaims); claims != nil {
-			v := claims[permission]
-			if v == nil || v.(bool) != true {
-				http.Error(w, "Operation not permitted", http.StatusForbidden)
-				return
-			}
-		} else {
-			logger.panic(r, errors.New("claims not found in context"))
+		if !hasPermission(r, permission) {
+			writeError(w, r, http.StatusForbidden, "Operation not permitted")
+			return
 		}
 		handlerFunc(w, r)
 	}
 }
+
+// hasPermission reports whether the JWT claims of the request grant the permission
+func hasPermission(r *http.Request, permission string) bool {
+	claims, ok := r.Context().Value(ctxKeyClaims).(jwt.MapClaims)
+	if !ok || claims == nil {
+		logger.panic(r, errors.New("claims not found in context"))
+	}
+	v, ok := claims[permission].(bool)
+	return ok && v
+}
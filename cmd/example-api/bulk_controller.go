@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBulkRowSize is the largest NDJSON row accepted by bulk imports
+const maxBulkRowSize = 1 << 20
+
+const (
+	bulkCreated = "created"
+	bulkFailed  = "failed"
+	bulkSkipped = "skipped"
+)
+
+// csvColumns are the columns accepted in the header of CSV imports
+var csvColumns = []string{"customerID", "description", "dueDate", "amount", "currency"}
+
+// malformedRowError represents a row of an import that could not be parsed
+type malformedRowError string
+
+func (e malformedRowError) Error() string {
+	return string(e)
+}
+
+// isBodyTooLarge reports whether err is the error of http.MaxBytesReader once
+// its limit is exceeded, which has no type of its own before Go 1.19
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+// invoiceRows reads the invoices of an import one row at a time. next returns
+// io.EOF after the last row. ValidationError and malformedRowError only apply
+// to the row read, any other error means the rest of the body is unreadable
+type invoiceRows interface {
+	next() (invoice, error)
+}
+
+type csvInvoiceRows struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+// newCSVInvoiceRows reads the header of a CSV import
+func newCSVInvoiceRows(body io.Reader) (*csvInvoiceRows, error) {
+	reader := csv.NewReader(body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, malformedRowError("Missing CSV header")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	invalid := InvalidFieldsError{}
+	for n, name := range header {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, c := range csvColumns {
+			known = known || c == name
+		}
+		if !known {
+			invalid = append(invalid, name)
+		}
+		columns[name] = n
+	}
+	if len(invalid) > 0 {
+		return nil, invalid
+	}
+	return &csvInvoiceRows{reader: reader, columns: columns}, nil
+}
+
+func (rows *csvInvoiceRows) next() (invoice, error) {
+	record, err := rows.reader.Read()
+	if err, ok := err.(*csv.ParseError); ok {
+		return invoice{}, malformedRowError(err.Error())
+	}
+	if err != nil {
+		return invoice{}, err
+	}
+
+	get := func(name string) string {
+		if n, ok := rows.columns[name]; ok {
+			return strings.TrimSpace(record[n])
+		}
+		return ""
+	}
+
+	i := invoice{Description: get("description"), Currency: get("currency")}
+	errs := ValidationError{}
+	if v := get("customerID"); v != "" {
+		if i.CustomerID, err = strconv.Atoi(v); err != nil {
+			errs = append(errs, fieldError{"customerID", "invalid", "Value must be an integer"})
+		}
+	}
+	if v := get("dueDate"); v != "" {
+		if t, err := parseTime(v); err != nil {
+			errs = append(errs, fieldError{"dueDate", "invalid", "Value must be an RFC 3339 timestamp or a date"})
+		} else {
+			i.DueDate = t.(time.Time)
+		}
+	}
+	if v := get("amount"); v != "" {
+		if i.Amount, err = parseDecimal(v); err != nil {
+			errs = append(errs, fieldError{"amount", "invalid", err.Error()})
+		}
+	}
+	if len(errs) > 0 {
+		return invoice{}, errs
+	}
+	return i, nil
+}
+
+type ndjsonInvoiceRows struct {
+	scanner *bufio.Scanner
+}
+
+func newNDJSONInvoiceRows(body io.Reader) *ndjsonInvoiceRows {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxBulkRowSize)
+	return &ndjsonInvoiceRows{scanner: scanner}
+}
+
+func (rows *ndjsonInvoiceRows) next() (invoice, error) {
+	for rows.scanner.Scan() {
+		line := bytes.TrimSpace(rows.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var i invoice
+		if err := json.Unmarshal(line, &i); err != nil {
+			return invoice{}, malformedRowError(err.Error())
+		}
+		return i, nil
+	}
+	if err := rows.scanner.Err(); err != nil {
+		return invoice{}, err
+	}
+	return invoice{}, io.EOF
+}
+
+// bulkCreateInvoices imports the invoices of a CSV or NDJSON body, and
+// responds with the outcome of each row. By default every valid row is
+// created. With atomic=true nothing is created unless all rows are valid.
+// Invoices are numbered from the series given by the series parameter
+func bulkCreateInvoices(w http.ResponseWriter, r *http.Request) {
+	atomic := false
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		var err error
+		if atomic, err = strconv.ParseBool(v); err != nil {
+			writeInvalidFields(w, r, InvalidFieldsError{"atomic"})
+			return
+		}
+	}
+	series, ok := numberSeriesParam(w, r)
+	if !ok {
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, int64(config.bulk.maxBodySize))
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var rows invoiceRows
+	switch mediaType {
+	case "text/csv":
+		csvRows, err := newCSVInvoiceRows(body)
+		if err, ok := err.(InvalidFieldsError); ok {
+			writeInvalidFields(w, r, err)
+			return
+		}
+		if isBodyTooLarge(err) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body must not be larger than %v bytes", config.bulk.maxBodySize))
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		rows = csvRows
+	case "application/x-ndjson", "application/ndjson":
+		rows = newNDJSONInvoiceRows(body)
+	default:
+		writeError(w, r, http.StatusUnsupportedMediaType, "Content-Type must be text/csv or application/x-ndjson")
+		return
+	}
+
+	ctx := r.Context()
+	imp := model.newImport(atomic, config.bulk.batchSize)
+	defer imp.abort()
+
+	report := bulkReport{Atomic: atomic, Results: []bulkResult{}}
+	for row := 1; ; row++ {
+		i, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			i.Series = series
+			if errs := i.validate(); len(errs) > 0 {
+				err = errs
+			}
+		}
+
+		result := bulkResult{Row: row}
+		switch {
+		case err == nil && atomic && report.Failed > 0:
+			// Nothing is created once an atomic import has failed, but the
+			// remaining rows are still validated
+			result.Status = bulkSkipped
+		case err == nil:
+			result.ID, err = imp.add(ctx, i)
+		}
+
+		switch err := err.(type) {
+		case nil:
+			if result.Status == "" {
+				result.Status = bulkCreated
+				report.Created++
+			}
+		case ValidationError:
+			result.Status = bulkFailed
+			result.Errors = err
+			report.Failed++
+		case malformedRowError, ReferenceError:
+			result.Status = bulkFailed
+			result.Detail = err.Error()
+			report.Failed++
+		default:
+			// Rows of batches already committed remain created
+			if isBodyTooLarge(err) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("Body must not be larger than %v bytes", config.bulk.maxBodySize))
+				return
+			}
+			writeModelError(w, r, err)
+			return
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	status := http.StatusOK
+	if atomic && report.Failed > 0 {
+		if err := imp.abort(); err != nil {
+			writeModelError(w, r, err)
+			return
+		}
+		for n := range report.Results {
+			if report.Results[n].Status == bulkCreated {
+				report.Results[n].Status = bulkSkipped
+				report.Results[n].ID = 0
+			}
+		}
+		report.Created = 0
+		status = http.StatusUnprocessableEntity
+	} else if err := imp.finish(); err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.panic(r, err)
+	}
+}
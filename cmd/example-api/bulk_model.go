@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// invoiceImport inserts invoices in batched transactions. Each invoice is
+// inserted under its own savepoint, so an invoice that fails does not affect
+// the others in its batch. When atomic, all invoices share one transaction
+// which is only committed by finish
+type invoiceImport struct {
+	db        *sql.DB
+	atomic    bool
+	batchSize int
+	tx        *sql.Tx
+	pending   int
+}
+
+func (model *invoicesModel) newImport(atomic bool, batchSize int) *invoiceImport {
+	return &invoiceImport{db: model.db, atomic: atomic, batchSize: batchSize}
+}
+
+// add inserts the invoice and returns its ID. Whatever this invoice inserted
+// is rolled back when it fails, so ValidationError and ReferenceError only
+// fail this invoice. Any other error leaves the import unusable
+func (imp *invoiceImport) add(ctx context.Context, i invoice) (int, error) {
+	if imp.tx == nil {
+		tx, err := imp.db.BeginTx(ctx, nil)
+		if err != nil {
+			return 0, err
+		}
+		imp.tx = tx
+	}
+
+	if _, err := imp.tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+		return 0, err
+	}
+	ID, err := insertInvoice(ctx, imp.tx, i)
+	if err != nil {
+		if _, rollbackErr := imp.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); rollbackErr != nil {
+			return 0, rollbackErr
+		}
+		return 0, err
+	}
+	if _, err := imp.tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row"); err != nil {
+		return 0, err
+	}
+
+	imp.pending++
+	if !imp.atomic && imp.pending >= imp.batchSize {
+		return ID, imp.commit()
+	}
+	return ID, nil
+}
+
+// finish commits the invoices not yet committed
+func (imp *invoiceImport) finish() error {
+	if imp.tx == nil {
+		return nil
+	}
+	return imp.commit()
+}
+
+// abort rolls back the invoices not yet committed
+func (imp *invoiceImport) abort() error {
+	if imp.tx == nil {
+		return nil
+	}
+	err := imp.tx.Rollback()
+	imp.tx = nil
+	imp.pending = 0
+	return err
+}
+
+func (imp *invoiceImport) commit() error {
+	err := imp.tx.Commit()
+	imp.tx = nil
+	imp.pending = 0
+	return err
+}
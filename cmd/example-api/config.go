@@ -3,13 +3,25 @@ package main
 import (
 	"fmt"
 	"github.com/joho/godotenv"
+	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 type conf struct {
-	port string
-	db   confDB
-	jwt  confJWT
+	port        string
+	db          confDB
+	jwt         confJWT
+	pagination  confPagination
+	idempotency confIdempotency
+	bulk        confBulk
+	pdf         confPDF
+	supplier    confSupplier
+	overdue     confOverdue
+	generator   confGenerator
+	numbering   confNumbering
+	tax         confTax
 }
 
 type confDB struct {
@@ -24,6 +36,48 @@ type confJWT struct {
 	secret string
 }
 
+type confPagination struct {
+	defaultLimit int
+	maxLimit     int
+}
+
+type confIdempotency struct {
+	ttl time.Duration
+}
+
+type confPDF struct {
+	template string
+}
+
+// confSupplier identifies the company issuing the invoices in e-invoices
+type confSupplier struct {
+	name           string
+	country        string
+	endpointID     string
+	endpointScheme string
+}
+
+type confOverdue struct {
+	interval time.Duration
+}
+
+type confGenerator struct {
+	interval time.Duration
+}
+
+type confTax struct {
+	jurisdiction string
+}
+
+type confNumbering struct {
+	series map[string]numberSeries
+}
+
+type confBulk struct {
+	batchSize   int
+	maxBodySize int
+}
+
 func newConfig() conf {
 	godotenv.Load(os.ExpandEnv("$GOPATH/src/github.com/jonbern/go-example-api/.env"))
 
@@ -39,6 +93,38 @@ func newConfig() conf {
 		jwt: confJWT{
 			secret: os.Getenv("JWT_SECRET"),
 		},
+		pagination: confPagination{
+			defaultLimit: getEnvPositiveIntOrDefault("PAGE_SIZE_DEFAULT", 100),
+			maxLimit:     getEnvPositiveIntOrDefault("PAGE_SIZE_MAX", 1000),
+		},
+		idempotency: confIdempotency{
+			ttl: getEnvDurationOrDefault("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		},
+		bulk: confBulk{
+			batchSize:   getEnvIntOrDefault("BULK_BATCH_SIZE", 500),
+			maxBodySize: getEnvIntOrDefault("BULK_MAX_BODY_SIZE", 100<<20),
+		},
+		pdf: confPDF{
+			template: getEnvOrDefault("PDF_TEMPLATE", "default"),
+		},
+		overdue: confOverdue{
+			interval: getEnvDurationOrDefault("OVERDUE_JOB_INTERVAL", time.Hour),
+		},
+		generator: confGenerator{
+			interval: getEnvDurationOrDefault("GENERATOR_JOB_INTERVAL", 5*time.Minute),
+		},
+		numbering: confNumbering{
+			series: getEnvNumberSeriesOrDefault("INVOICE_NUMBER_SERIES", "default:INV-:6:yearly"),
+		},
+		tax: confTax{
+			jurisdiction: getEnvOrDefault("TAX_JURISDICTION", "NO"),
+		},
+		supplier: confSupplier{
+			name:           getEnvOrDefault("SUPPLIER_NAME", "Example Supplier"),
+			country:        getEnvOrDefault("SUPPLIER_COUNTRY", "NO"),
+			endpointID:     os.Getenv("SUPPLIER_ENDPOINT_ID"),
+			endpointScheme: getEnvOrDefault("SUPPLIER_ENDPOINT_SCHEME", "0192"),
+		},
 	}
 }
 
@@ -55,3 +141,45 @@ func getEnvOrDefault(envName string, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvIntOrDefault(envName string, defaultValue int) int {
+	value := os.Getenv(envName)
+
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("%v env variable must be an integer: %v", envName, err))
+	}
+	return i
+}
+
+func getEnvPositiveIntOrDefault(envName string, defaultValue int) int {
+	i := getEnvIntOrDefault(envName, defaultValue)
+	if i < 1 {
+		log.Fatal(fmt.Sprintf("%v env variable must be a positive integer, got %v", envName, i))
+	}
+	return i
+}
+
+func getEnvDurationOrDefault(envName string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(envName)
+
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatal(fmt.Sprintf("%v env variable must be a duration: %v", envName, err))
+	}
+	return d
+}
+
+func getEnvNumberSeriesOrDefault(envName string, defaultValue string) map[string]numberSeries {
+	series, err := parseNumberSeries(getEnvOrDefault(envName, defaultValue))
+	if err != nil {
+		log.Fatal(fmt.Sprintf("%v env variable is invalid: %v", envName, err))
+	}
+	return series
+}
@@ -8,11 +8,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
 
+// maxBodySize is the maximum number of bytes read from request bodies
+const maxBodySize = 10000
+
 func optionsResponse(methods string) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Methods", methods)
@@ -21,59 +26,172 @@ func optionsResponse(methods string) func(w http.ResponseWriter, r *http.Request
 }
 
 func getInvoices(w http.ResponseWriter, r *http.Request) {
-	ctx := context.TODO()
+	q, err := parseInvoiceQuery(r.URL.Query())
+	if err != nil {
+		writeInvalidFields(w, r, err.(InvalidFieldsError))
+		return
+	}
+	if q.includeDeleted && !hasPermission(r, "getDeletedInvoices") {
+		writeError(w, r, http.StatusForbidden, "Operation not permitted")
+		return
+	}
+
+	writeInvoicePage(w, r, q)
+}
+
+// searchInvoices responds with the invoices best matching the q query
+// parameter, the most relevant first
+func searchInvoices(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	invalid := InvalidFieldsError{}
+
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" || utf8.RuneCountInString(q) > maxSearchQueryLength {
+		invalid = append(invalid, "q")
+	}
+	limit, err := parseLimit(query)
+	if err != nil {
+		invalid = append(invalid, "limit")
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, r, invalid)
+		return
+	}
+
+	ctx := r.Context()
+	results, err := model.search(ctx, q, limit)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(searchResults{Results: results}); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+// writeInvoicePage responds with the page of invoices selected by q, or with
+// an export of all of them when CSV, TSV or NDJSON is accepted
+func writeInvoicePage(w http.ResponseWriter, r *http.Request, q invoiceQuery) {
+	switch mediaType := negotiate(r, mediaTypeJSON, mediaTypeCSV, mediaTypeTSV, mediaTypeNDJSON); mediaType {
+	case mediaTypeJSON:
+	case "":
+		writeError(w, r, http.StatusNotAcceptable, "Supported media types are application/json, text/csv, text/tab-separated-values and application/x-ndjson")
+		return
+	default:
+		exportInvoices(w, r, q, mediaType)
+		return
+	}
+
+	ctx := r.Context()
 
 	errCh := make(chan error)
-	ch := make(chan []invoice)
+	ch := make(chan invoicePage)
 	timeout := time.After(50 * time.Millisecond)
 
 	go func() {
-		invoices, err := model.getAll(ctx)
+		page, err := model.getPage(ctx, q)
 		if err != nil {
 			errCh <- err
 		} else {
-			ch <- invoices
+			ch <- page
 		}
 	}()
 
 	select {
-	case invoices := <-ch:
+	case page := <-ch:
+		if page.NextCursor != "" {
+			w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"next\"", nextPageURL(r.URL, page.NextCursor)))
+		}
 		w.WriteHeader(http.StatusOK)
 
 		encoder := json.NewEncoder(w)
 		encoder.SetIndent("", "    ")
 
-		if err := encoder.Encode(invoices); err != nil {
+		if err := encoder.Encode(page); err != nil {
 			logger.panic(r, err)
 		}
 	case <-timeout:
-		http.Error(w, "Request timed out", http.StatusGatewayTimeout)
+		writeError(w, r, http.StatusGatewayTimeout, "Request timed out")
 	case err := <-errCh:
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeError(w, r, http.StatusInternalServerError, "Internal server error")
 		logger.error(r, err)
 	}
 }
 
 func getInvoice(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Could not convert id=%d to integer", id), http.StatusUnprocessableEntity)
-		logger.error(r, err)
+	id, ok := intVar(w, r, "id")
+	if !ok {
 		return
 	}
 
-	ctx := context.TODO()
-	invoice, err := model.getByID(ctx, id)
-	if err != nil {
-		switch err.(type) {
-		case NotFoundError:
-			http.Error(w, err.Error(), http.StatusNotFound)
-			logger.error(r, err)
-		default:
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			logger.error(r, err)
+	writeInvoice(w, r, func(ctx context.Context, includeDeleted bool) (invoice, error) {
+		if includeDeleted {
+			return model.getByIDIncludingDeleted(ctx, id)
 		}
+		return model.getByID(ctx, id)
+	})
+}
+
+func getInvoiceByNumber(w http.ResponseWriter, r *http.Request) {
+	number := mux.Vars(r)["number"]
+	writeInvoice(w, r, func(ctx context.Context, includeDeleted bool) (invoice, error) {
+		return model.getByNumber(ctx, number, includeDeleted)
+	})
+}
+
+// writeInvoice responds with the invoice returned by getFn in the negotiated
+// media type
+func writeInvoice(w http.ResponseWriter, r *http.Request, getFn func(ctx context.Context, includeDeleted bool) (invoice, error)) {
+	mediaType := negotiate(r, mediaTypeJSON, mediaTypePDF, mediaTypeXML)
+	if mediaType == "" {
+		writeError(w, r, http.StatusNotAcceptable, "Supported media types are application/json, application/pdf and application/xml")
+		return
+	}
+
+	var err error
+
+	includeDeleted := false
+	if v := r.URL.Query().Get("includeDeleted"); v != "" {
+		includeDeleted, err = strconv.ParseBool(v)
+		if err != nil {
+			writeInvalidFields(w, r, InvalidFieldsError{"includeDeleted"})
+			return
+		}
+	}
+	if includeDeleted && !hasPermission(r, "getDeletedInvoices") {
+		writeError(w, r, http.StatusForbidden, "Operation not permitted")
+		return
+	}
+
+	ctx := r.Context()
+	invoice, err := getFn(ctx, includeDeleted)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	// PDF and UBL documents also show customer details, so the version of the
+	// invoice does not identify them and they get no entity tag
+	w.Header().Set("Vary", "Accept")
+	switch mediaType {
+	case mediaTypePDF:
+		writeInvoicePDF(w, r, invoice)
+		return
+	case mediaTypeXML:
+		writeInvoiceUBL(w, r, invoice)
+		return
+	}
+
+	etag := invoiceETag(invoice)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -89,35 +207,287 @@ func getInvoice(w http.ResponseWriter, r *http.Request) {
 }
 
 func createInvoice(w http.ResponseWriter, r *http.Request) {
+	series, ok := numberSeriesParam(w, r)
+	if !ok {
+		return
+	}
+
 	var i invoice
-	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1000))
+	if !readJSON(w, r, &i) {
+		return
+	}
+	i.Series = series
+
+	if errs := i.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := model.create(ctx, i)
 	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", invoiceETag(result))
+	w.Header().Set("Location", fmt.Sprintf("/invoices/%d", result.ID))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		logger.panic(r, err)
 	}
-	if err := r.Body.Close(); err != nil {
+}
+
+func updateInvoice(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var i invoice
+	if !readJSON(w, r, &i) {
+		return
+	}
+	i.ID = id
+
+	if errs := i.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := model.update(ctx, i, version)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(result))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		logger.panic(r, err)
 	}
-	if err := json.Unmarshal(body, &i); err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		if err := json.NewEncoder(w).Encode(err); err != nil {
+}
+
+// patchInvoice applies a JSON Merge Patch (RFC 7396) to an existing invoice
+func patchInvoice(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var patch interface{}
+	if !readJSON(w, r, &patch) {
+		return
+	}
+
+	ctx := r.Context()
+	existing, err := model.getByID(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	i, err := applyMergePatch(existing, patch)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+		logger.info(r, err.Error())
+		return
+	}
+	i.ID = id
+
+	if errs := i.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	result, err := model.update(ctx, i, version)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(result))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func deleteInvoice(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := model.delete(ctx, id, version); err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func restoreInvoice(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	result, err := model.restore(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(result))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func getInvoiceHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	events, err := model.getHistory(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+// transitionInvoice returns a handler changing the status of an invoice to the given status
+func transitionInvoice(to invoiceStatus) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := intVar(w, r, "id")
+		if !ok {
+			return
+		}
+
+		ctx := r.Context()
+		result, err := model.transition(ctx, id, to)
+		if err != nil {
+			writeModelError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
 			logger.panic(r, err)
 		}
-		return
 	}
+}
 
-	ctx := context.TODO()
-	result, err := model.create(ctx, i)
+// intVar reads the named route variable as an integer, responding with 422
+// when it is not one
+func intVar(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	value := mux.Vars(r)[name]
+	i, err := strconv.Atoi(value)
 	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Could not convert %v=%v to integer", name, value))
 		logger.error(r, err)
+		return 0, false
 	}
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(result); err != nil {
+	return i, true
+}
+
+// numberSeriesParam returns the number series requested by the series query
+// parameter, responding with 400 when the series is not configured
+func numberSeriesParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	series := r.URL.Query().Get("series")
+	if series == "" {
+		return defaultNumberSeries, true
+	}
+	if _, ok := config.numbering.series[series]; !ok {
+		writeInvalidFields(w, r, InvalidFieldsError{"series"})
+		return "", false
+	}
+	return series, true
+}
+
+// readJSON decodes the request body into v, responding with 422 when the
+// body is not valid JSON
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+	if err != nil {
+		logger.panic(r, err)
+	}
+	if err := r.Body.Close(); err != nil {
 		logger.panic(r, err)
 	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+		logger.info(r, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeInvalidFields responds with 400 listing the invalid fields
+func writeInvalidFields(w http.ResponseWriter, r *http.Request, err InvalidFieldsError) {
+	logger.info(r, err.Error())
+
+	p := newProblem(http.StatusBadRequest, err.Error())
+	p.InvalidFields = err
+	writeProblem(w, r, p)
+}
+
+// writeValidationError responds with 422 listing the invalid fields
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs ValidationError) {
+	logger.info(r, errs.Error())
+
+	p := newProblem(http.StatusUnprocessableEntity, errs.Error())
+	p.Errors = errs
+	writeProblem(w, r, p)
+}
+
+// writeModelError maps errors returned by the model to HTTP responses
+func writeModelError(w http.ResponseWriter, r *http.Request, err error) {
+	logger.error(r, err)
+	switch err.(type) {
+	case NotFoundError:
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case ValidationError:
+		writeValidationError(w, r, err.(ValidationError))
+	case ReferenceError:
+		writeError(w, r, http.StatusUnprocessableEntity, err.Error())
+	case ConflictError, InvalidTransitionError:
+		writeError(w, r, http.StatusConflict, err.Error())
+	case PreconditionFailedError:
+		writeError(w, r, http.StatusPreconditionFailed, err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, "Internal server error")
+	}
 }
 
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	msg := r.Method + " " + r.URL.RequestURI()
 	logger.info(r, msg+" "+strconv.Itoa(http.StatusNotFound))
-	http.Error(w, msg, http.StatusNotFound)
+	writeError(w, r, http.StatusNotFound, msg)
 }
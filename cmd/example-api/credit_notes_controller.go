@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func getInvoiceCreditNotes(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	creditNotes, err := model.getCreditNotes(ctx, invoiceID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(creditNotes); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func getCreditNote(w http.ResponseWriter, r *http.Request) {
+	ID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	c, err := model.getCreditNote(ctx, ID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(c); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func createInvoiceCreditNote(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var c creditNote
+	if !readJSON(w, r, &c) {
+		return
+	}
+	if errs := c.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := model.createCreditNote(ctx, invoiceID, c)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
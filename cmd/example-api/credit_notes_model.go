@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const creditNoteColNames = "ID, Number, InvoiceID, Amount, Reason, CreatedAt"
+
+// creditNoteSequence returns the number sequence the credit notes of the
+// tenant are numbered from
+func creditNoteSequence(tenant string) string {
+	return "creditNote:" + tenant
+}
+
+func parseCreditNoteRow(scanFn func(...interface{}) error) (creditNote, error) {
+	var c creditNote
+	var reason sql.NullString
+	if err := scanFn(&c.ID, &c.Number, &c.InvoiceID, &c.Amount, &reason, &c.CreatedAt); err != nil {
+		return creditNote{}, err
+	}
+	c.Reason = reason.String
+	return c, nil
+}
+
+func (model *invoicesModel) getCreditNotes(ctx context.Context, invoiceID int) ([]creditNote, error) {
+	if _, err := model.getByID(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM credit_notes WHERE InvoiceID=? ORDER BY ID", creditNoteColNames),
+		invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creditNotes := []creditNote{}
+	for rows.Next() {
+		c, err := parseCreditNoteRow(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		creditNotes = append(creditNotes, c)
+	}
+	return creditNotes, rows.Err()
+}
+
+func (model *invoicesModel) getCreditNote(ctx context.Context, ID int) (creditNote, error) {
+	c, err := parseCreditNoteRow(model.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %v FROM credit_notes WHERE ID=? AND TenantID=?", creditNoteColNames), ID, tenantOf(ctx)).Scan)
+	if err == sql.ErrNoRows {
+		return creditNote{}, NotFoundError(fmt.Sprintf("Credit note with ID=%d not found", ID))
+	}
+	return c, err
+}
+
+// createCreditNote credits an amount against an issued, overdue or paid
+// invoice, reducing its outstanding balance. The invoice is marked as paid
+// once nothing is outstanding. The credited total cannot exceed the invoice
+// amount
+func (model *invoicesModel) createCreditNote(ctx context.Context, invoiceID int, c creditNote) (creditNote, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return creditNote{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, invoiceID)
+	if err != nil {
+		return creditNote{}, err
+	}
+	if existing.Status != statusIssued && existing.Status != statusOverdue && existing.Status != statusPaid {
+		return creditNote{}, ConflictError(fmt.Sprintf("Invoice with ID=%d is %v and cannot be credited", invoiceID, existing.Status))
+	}
+	if errs := (money{c.Amount, existing.Currency}).validate("amount", "currency"); len(errs) > 0 {
+		return creditNote{}, errs
+	}
+	if remaining := existing.Amount.sub(existing.Credited); c.Amount.cmp(remaining) > 0 {
+		return creditNote{}, ValidationError{{"amount", "exceedsInvoice", fmt.Sprintf("Credit exceeds the uncredited amount of %v %v", remaining, existing.Currency)}}
+	}
+
+	n, err := nextNumber(ctx, tx, creditNoteSequence(tenantOf(ctx)))
+	if err != nil {
+		return creditNote{}, err
+	}
+
+	var reason sql.NullString
+	if c.Reason != "" {
+		reason = sql.NullString{String: c.Reason, Valid: true}
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO credit_notes (TenantID, Number, InvoiceID, Amount, Reason, CreatedAt) VALUES (?, ?, ?, ?, ?, ?)",
+		tenantOf(ctx),
+		fmt.Sprintf("CN-%06d", n),
+		invoiceID,
+		c.Amount,
+		reason,
+		time.Now().UTC())
+	if err != nil {
+		return creditNote{}, err
+	}
+	ID, err := result.LastInsertId()
+	if err != nil {
+		return creditNote{}, err
+	}
+
+	status := existing.Status
+	if remaining := existing.Balance.sub(c.Amount); remaining.isZero() || remaining.isNegative() {
+		status = statusPaid
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET Status=?, Version=Version+1 WHERE ID=?", status, invoiceID); err != nil {
+		return creditNote{}, err
+	}
+	if err := audit(ctx, tx, actionCreditNote, invoiceID, &existing); err != nil {
+		return creditNote{}, err
+	}
+
+	created, err := parseCreditNoteRow(tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %v FROM credit_notes WHERE ID=?", creditNoteColNames), ID).Scan)
+	if err != nil {
+		return creditNote{}, err
+	}
+	return created, tx.Commit()
+}
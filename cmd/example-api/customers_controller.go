@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+func getCustomers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	invalid := InvalidFieldsError{}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		invalid = append(invalid, "limit")
+	}
+	afterID, err := parseIDCursor(query)
+	if err != nil {
+		invalid = append(invalid, "cursor")
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, r, invalid)
+		return
+	}
+
+	ctx := r.Context()
+	page, err := customerModel.getPage(ctx, afterID, limit)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"next\"", nextPageURL(r.URL, page.NextCursor)))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(page); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func getCustomer(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	customer, err := customerModel.getByID(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(customer); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func createCustomer(w http.ResponseWriter, r *http.Request) {
+	var c customer
+	if !readJSON(w, r, &c) {
+		return
+	}
+
+	if errs := c.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := customerModel.create(ctx, c)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func updateCustomer(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var c customer
+	if !readJSON(w, r, &c) {
+		return
+	}
+
+	if errs := c.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	c.ID = id
+
+	ctx := r.Context()
+	result, err := customerModel.update(ctx, c)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func deleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := customerModel.delete(ctx, id); err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func getCustomerInvoices(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	q, err := parseInvoiceQuery(r.URL.Query())
+	if err != nil {
+		writeInvalidFields(w, r, err.(InvalidFieldsError))
+		return
+	}
+	if q.includeDeleted && !hasPermission(r, "getDeletedInvoices") {
+		writeError(w, r, http.StatusForbidden, "Operation not permitted")
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := customerModel.getByID(ctx, id); err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	q.filters = append(q.filters, condition{"CustomerID = ?", []interface{}{id}})
+	writeInvoicePage(w, r, q)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const customerColNames string = "ID, Name, Email, Address"
+
+// MySQL error numbers for foreign key violations
+const (
+	errRowIsReferenced uint16 = 1451
+	errNoReferencedRow uint16 = 1452
+)
+
+func isMySQLError(err error, number uint16) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == number
+}
+
+type customersModel struct {
+	db *sql.DB
+}
+
+func newCustomersModel(db *sql.DB) customersModel {
+	return customersModel{db: db}
+}
+
+func parseCustomerRow(scanFn func(...interface{}) error) (customer, error) {
+	var c customer
+	var email sql.NullString
+	var address sql.NullString
+
+	if err := scanFn(
+		&c.ID,
+		&c.Name,
+		&email,
+		&address); err != nil {
+		return customer{}, err
+	}
+
+	c.Email = email.String
+	c.Address = address.String
+	return c, nil
+}
+
+func (model *customersModel) create(ctx context.Context, c customer) (customer, error) {
+	result, err := model.db.ExecContext(ctx,
+		"INSERT INTO customers (TenantID, Name, Email, Address) VALUES (?, ?, ?, ?)",
+		tenantOf(ctx),
+		c.Name,
+		c.Email,
+		c.Address)
+	if err != nil {
+		return customer{}, err
+	}
+	ID, err := result.LastInsertId()
+	if err != nil {
+		return customer{}, err
+	}
+
+	return model.getByID(ctx, int(ID))
+}
+
+// getPage returns up to limit customers of the tenant ordered by ID, starting
+// after afterID
+func (model *customersModel) getPage(ctx context.Context, afterID int, limit int) (customerPage, error) {
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM customers WHERE TenantID=? AND ID > ? ORDER BY ID LIMIT ?", customerColNames),
+		tenantOf(ctx),
+		afterID,
+		limit+1)
+	if err != nil {
+		return customerPage{}, err
+	}
+	defer rows.Close()
+
+	customers := []customer{}
+	for rows.Next() {
+		c, err := parseCustomerRow(rows.Scan)
+		if err != nil {
+			return customerPage{}, err
+		}
+		customers = append(customers, c)
+	}
+	if err := rows.Err(); err != nil {
+		return customerPage{}, err
+	}
+
+	page := customerPage{Customers: customers}
+	if len(customers) > limit {
+		page.Customers = customers[:limit]
+		page.NextCursor = encodeIDCursor(page.Customers[limit-1].ID)
+	}
+	return page, nil
+}
+
+func (model *customersModel) getByID(ctx context.Context, ID int) (customer, error) {
+	row := model.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %v FROM customers WHERE ID=? AND TenantID=?", customerColNames), ID, tenantOf(ctx))
+	c, err := parseCustomerRow(row.Scan)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return customer{}, NotFoundError(fmt.Sprintf("Customer with ID=%d not found", ID))
+	case err != nil:
+		return customer{}, err
+	default:
+		return c, nil
+	}
+}
+
+// checkCustomer returns ReferenceError unless the customer belongs to the
+// tenant, so that invoices and schedules only refer to customers of their own
+func checkCustomer(ctx context.Context, q queryer, ID int) error {
+	var found int
+	err := q.QueryRowContext(ctx, "SELECT 1 FROM customers WHERE ID=? AND TenantID=?", ID, tenantOf(ctx)).Scan(&found)
+	if err == sql.ErrNoRows {
+		return ReferenceError(fmt.Sprintf("Customer with ID=%d not found", ID))
+	}
+	return err
+}
+
+func (model *customersModel) update(ctx context.Context, c customer) (customer, error) {
+	if _, err := model.getByID(ctx, c.ID); err != nil {
+		return customer{}, err
+	}
+
+	_, err := model.db.ExecContext(ctx,
+		"UPDATE customers SET Name=?, Email=?, Address=? WHERE ID=? AND TenantID=?",
+		c.Name,
+		c.Email,
+		c.Address,
+		c.ID,
+		tenantOf(ctx))
+	if err != nil {
+		return customer{}, err
+	}
+
+	return model.getByID(ctx, c.ID)
+}
+
+func (model *customersModel) delete(ctx context.Context, ID int) error {
+	result, err := model.db.ExecContext(ctx, "DELETE FROM customers WHERE ID=? AND TenantID=?", ID, tenantOf(ctx))
+	if isMySQLError(err, errRowIsReferenced) {
+		return ConflictError(fmt.Sprintf("Customer with ID=%d has invoices or schedules and cannot be deleted", ID))
+	}
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NotFoundError(fmt.Sprintf("Customer with ID=%d not found", ID))
+	}
+	return nil
+}
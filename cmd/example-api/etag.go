@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// invoiceETag returns the entity tag of the current version of the invoice
+func invoiceETag(i invoice) string {
+	return fmt.Sprintf(`"%d"`, i.Version)
+}
+
+// parseETagVersion extracts the version from a strong entity tag
+func parseETagVersion(tag string) (int, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		return 0, false
+	}
+	version, err := strconv.Atoi(tag[1 : len(tag)-1])
+	if err != nil || version < 1 {
+		return 0, false
+	}
+	return version, true
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches the entity tag,
+// using weak comparison as required by RFC 7232
+func ifNoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion reads the version required by the If-Match header, responding
+// with 428 when the header is missing and 412 when it cannot match. A wildcard
+// matches any version and is returned as 0
+func ifMatchVersion(w http.ResponseWriter, r *http.Request) (int, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		writeError(w, r, http.StatusPreconditionRequired, "If-Match header is required")
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	version, ok := parseETagVersion(header)
+	if !ok {
+		writeError(w, r, http.StatusPreconditionFailed, fmt.Sprintf("If-Match=%v does not match the current version", header))
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeCSV    = "text/csv"
+	mediaTypeTSV    = "text/tab-separated-values"
+	mediaTypeNDJSON = "application/x-ndjson"
+)
+
+// invoiceExportColumns are the columns of CSV and TSV exports
+var invoiceExportColumns = []string{"id", "invoiceNumber", "customerID", "description", "dueDate", "taxMode", "netAmount", "taxAmount", "amount", "credited", "balance", "currency", "status", "deletedAt"}
+
+// invoiceEncoder writes invoices to an export one at a time
+type invoiceEncoder interface {
+	encode(i invoice) error
+	flush() error
+}
+
+type csvInvoiceEncoder struct {
+	writer *csv.Writer
+}
+
+// newCSVInvoiceEncoder returns an encoder writing a header row followed by a
+// row per invoice, with fields separated by comma
+func newCSVInvoiceEncoder(w io.Writer, comma rune) *csvInvoiceEncoder {
+	writer := csv.NewWriter(w)
+	writer.Comma = comma
+	writer.Write(invoiceExportColumns)
+	return &csvInvoiceEncoder{writer: writer}
+}
+
+func (e *csvInvoiceEncoder) encode(i invoice) error {
+	var dueDate, deletedAt string
+	if !i.DueDate.IsZero() {
+		dueDate = i.DueDate.Format(time.RFC3339)
+	}
+	if i.DeletedAt != nil {
+		deletedAt = i.DeletedAt.Format(time.RFC3339)
+	}
+
+	return e.writer.Write([]string{
+		strconv.Itoa(i.ID),
+		neutralizeFormula(i.Number),
+		strconv.Itoa(i.CustomerID),
+		neutralizeFormula(i.Description),
+		dueDate,
+		string(i.TaxMode),
+		i.NetAmount.String(),
+		i.TaxAmount.String(),
+		i.Amount.String(),
+		i.Credited.String(),
+		i.Balance.String(),
+		i.Currency,
+		string(i.Status),
+		deletedAt,
+	})
+}
+
+// neutralizeFormula prefixes text starting like a formula with a quote, so
+// spreadsheets opening the export show the text rather than evaluate it.
+// Amounts are written by the API itself and left as they are, so negative
+// amounts stay numbers
+func neutralizeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (e *csvInvoiceEncoder) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type ndjsonInvoiceEncoder struct {
+	encoder *json.Encoder
+}
+
+func newNDJSONInvoiceEncoder(w io.Writer) *ndjsonInvoiceEncoder {
+	return &ndjsonInvoiceEncoder{encoder: json.NewEncoder(w)}
+}
+
+func (e *ndjsonInvoiceEncoder) encode(i invoice) error {
+	return e.encoder.Encode(i)
+}
+
+func (e *ndjsonInvoiceEncoder) flush() error {
+	return nil
+}
+
+// exportWriter records whether any of an export has been written, after which
+// the status has been sent and errors can no longer be reported
+type exportWriter struct {
+	io.Writer
+	started bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.Writer.Write(p)
+}
+
+// exportInvoices streams every invoice selected by q, ignoring its page limit.
+// The connection is aborted when the export fails after it has started, so
+// clients cannot mistake a truncated export for a complete one
+func exportInvoices(w http.ResponseWriter, r *http.Request, q invoiceQuery, mediaType string) {
+	out := &exportWriter{Writer: w}
+	var enc invoiceEncoder
+	extension := "ndjson"
+	switch mediaType {
+	case mediaTypeCSV:
+		enc = newCSVInvoiceEncoder(out, ',')
+		extension = "csv"
+	case mediaTypeTSV:
+		enc = newCSVInvoiceEncoder(out, '\t')
+		extension = "tsv"
+	default:
+		enc = newNDJSONInvoiceEncoder(out)
+	}
+	w.Header().Set("Content-Type", mediaType+"; charset=UTF-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoices.%v\"", extension))
+
+	// The header row is sent before the invoices are read, so no failure can
+	// go unnoticed behind rows still buffered by the encoder
+	err := enc.flush()
+	if err == nil {
+		err = model.each(r.Context(), q, enc.encode)
+	}
+	if err == nil {
+		err = enc.flush()
+	}
+
+	switch {
+	case err != nil && !out.started:
+		// Nothing has been sent yet, so the error can still be reported
+		w.Header().Del("Content-Disposition")
+		writeModelError(w, r, err)
+	case err != nil:
+		logger.error(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// negotiate returns the offer with the highest quality in the Accept header of
+// r, preferring earlier offers on ties, or "" if no offer is acceptable. The
+// first offer is returned when there is no Accept header
+func negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQuality {
+			best, bestQuality = offer, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality the Accept header assigns to the media
+// type, as given by its most specific matching range
+func acceptQuality(accept string, mediaType string) float64 {
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		s := -1
+		switch {
+		case accepted == mediaType:
+			s = 2
+		case strings.HasSuffix(accepted, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(accepted, "*")):
+			s = 1
+		case accepted == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, quality = s, 1
+		if v, ok := params["q"]; ok {
+			if q, err := strconv.ParseFloat(v, 64); err == nil {
+				quality = q
+			}
+		}
+	}
+	return quality
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mediaTypeJSON, mediaTypeCSV, mediaTypeNDJSON}
+	for _, x := range []struct {
+		accept   string
+		expected string
+	}{
+		{"", mediaTypeJSON},
+		{"*/*", mediaTypeJSON},
+		{"text/csv", mediaTypeCSV},
+		{"text/*", mediaTypeCSV},
+		{"application/x-ndjson, application/json;q=0.5", mediaTypeNDJSON},
+		{"text/csv;q=0.9, */*;q=0.1", mediaTypeCSV},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", mediaTypeJSON},
+		{"*/*, text/csv;q=0", mediaTypeJSON},
+		{"text/plain", ""},
+	} {
+		t.Run(x.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/invoices", nil)
+			if x.accept != "" {
+				r.Header.Set("Accept", x.accept)
+			}
+			if result := negotiate(r, offers...); result != x.expected {
+				t.Errorf("Expected %q, got %q", x.expected, result)
+			}
+		})
+	}
+}
+
+func TestCSVInvoiceEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := newCSVInvoiceEncoder(&buf, ',')
+	enc.encode(invoice{
+		ID:          1,
+		Number:      "INV-2019-000001",
+		CustomerID:  2,
+		Description: "Chairs, desks",
+		DueDate:     time.Date(2019, 11, 23, 0, 0, 0, 0, time.UTC),
+		TaxMode:     taxExclusive,
+		NetAmount:   mustDecimal("8.4"),
+		TaxAmount:   mustDecimal("2.1"),
+		Amount:      mustDecimal("10.5"),
+		Credited:    mustDecimal("2"),
+		Balance:     mustDecimal("8.5"),
+		Currency:    "NOK",
+		Status:      statusIssued,
+	})
+	if err := enc.flush(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	expected := "id,invoiceNumber,customerID,description,dueDate,taxMode,netAmount,taxAmount,amount,credited,balance,currency,status,deletedAt\n" +
+		"1,INV-2019-000001,2,\"Chairs, desks\",2019-11-23T00:00:00Z,exclusive,8.4,2.1,10.5,2,8.5,NOK,issued,\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	t.Run("Neutralizes formulas", func(t *testing.T) {
+		for _, description := range []string{"=HYPERLINK(\"http://example.com\")", "+1+1", "-1+1", "@SUM(A1)", "\t=1"} {
+			var buf bytes.Buffer
+			enc := newCSVInvoiceEncoder(&buf, ',')
+			enc.encode(invoice{ID: 1, Description: description, Balance: mustDecimal("-5")})
+			if err := enc.flush(); err != nil {
+				t.Fatalf(err.Error())
+			}
+
+			records, err := csv.NewReader(&buf).ReadAll()
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if cell := records[1][3]; cell != "'"+description {
+				t.Errorf("Should prefix %q with a quote, got %q", description, cell)
+			}
+			if cell := records[1][10]; cell != "-5" {
+				t.Errorf("Should leave negative amounts as they are, got %q", cell)
+			}
+		}
+	})
+}
+
+func TestExportInvoices_Failure(t *testing.T) {
+	db, err := sql.Open("mysql", "user:password@/invoices")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	db.Close()
+
+	saved := model
+	model = newInvoicesModel(db)
+	defer func() { model = saved }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exportInvoices(w, r.WithContext(testContext()), invoiceQuery{}, r.Header.Get("Accept"))
+	}))
+	defer ts.Close()
+
+	get := func(mediaType string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		req.Header.Set("Accept", mediaType)
+		return http.DefaultClient.Do(req)
+	}
+
+	t.Run("Reports the error when nothing has been sent", func(t *testing.T) {
+		res, err := get(mediaTypeNDJSON)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 500 || res.Header.Get("Content-Disposition") != "" {
+			t.Errorf("Should return status code %v without attachment. Returned %v", 500, res.StatusCode)
+		}
+	})
+
+	t.Run("Aborts the connection once the header has been sent", func(t *testing.T) {
+		res, err := get(mediaTypeCSV)
+		if err == nil {
+			defer res.Body.Close()
+			_, err = ioutil.ReadAll(res.Body)
+		}
+		if err == nil {
+			t.Errorf("Should abort the connection")
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// generatorJob periodically creates the invoices of the runs of schedules
+// that are due, making up for runs missed while the job was not running
+func generatorJob(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		ctx := withActor(context.Background(), systemActor)
+		generated, locked, err := scheduleModel.generateDue(ctx, &model, time.Now().UTC())
+		switch {
+		case err != nil:
+			log.Println(fmt.Sprintf("ERROR: Generator job: %q (generated=%v)", err, generated))
+		case !locked:
+			log.Println("Generator job: skipped, running on another replica")
+		default:
+			log.Println(fmt.Sprintf("Generator job: generated=%v", generated))
+		}
+	}
+}
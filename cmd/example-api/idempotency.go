@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// replayedHeaders are the response headers stored along with the status code
+// and body, and replayed for repeated requests
+var replayedHeaders = []string{"ETag", "Location"}
+
+// responseRecorder passes the response through while keeping a copy of the
+// status code and body
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	rec.statusCode = statusCode
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent makes the handler safe to retry using the Idempotency-Key header.
+// The first response for a key is stored and replayed for repeated requests,
+// while reusing a key for a different request responds with 422
+func idempotent(handlerFunc func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handlerFunc(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeError(w, r, http.StatusBadRequest, "Idempotency-Key must not be longer than 255 characters")
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize))
+		if err != nil {
+			logger.panic(r, err)
+		}
+		if err := r.Body.Close(); err != nil {
+			logger.panic(r, err)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		ctx := r.Context()
+		stored, claimed, err := idempotencyKeyModel.claim(ctx, key, requestHash)
+		if err != nil {
+			writeModelError(w, r, err)
+			return
+		}
+
+		switch {
+		case claimed:
+		case stored.requestHash != requestHash:
+			writeError(w, r, http.StatusUnprocessableEntity, "Idempotency-Key has already been used for a different request")
+			return
+		case stored.statusCode == 0:
+			writeError(w, r, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+			return
+		default:
+			logger.info(r, "Replaying response for Idempotency-Key="+key)
+			w.Header().Set("Content-Type", stored.contentType)
+			for name, values := range stored.headers {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.statusCode)
+			if _, err := w.Write(stored.body); err != nil {
+				logger.error(r, err)
+			}
+			return
+		}
+
+		// The key is released if the handler panics, so retries are not
+		// refused until the key expires
+		defer func() {
+			if p := recover(); p != nil {
+				if err := idempotencyKeyModel.release(ctx, key); err != nil {
+					logger.error(r, err)
+				}
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		handlerFunc(rec, r)
+
+		// Server errors are not stored, allowing the client to retry
+		if rec.statusCode >= http.StatusInternalServerError {
+			if err := idempotencyKeyModel.release(ctx, key); err != nil {
+				logger.error(r, err)
+			}
+			return
+		}
+
+		headers := http.Header{}
+		for _, name := range replayedHeaders {
+			if values, ok := rec.Header()[name]; ok {
+				headers[name] = values
+			}
+		}
+		err = idempotencyKeyModel.complete(ctx, key, storedResponse{
+			statusCode:  rec.statusCode,
+			contentType: rec.Header().Get("Content-Type"),
+			headers:     headers,
+			body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			logger.error(r, err)
+		} else {
+			logger.info(r, "Stored response for Idempotency-Key="+key+" status="+strconv.Itoa(rec.statusCode))
+		}
+	}
+}
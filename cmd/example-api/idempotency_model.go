@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// MySQL error number for duplicate primary or unique keys
+const errDupEntry uint16 = 1062
+
+// storedResponse is the response recorded for an idempotency key. A zero
+// statusCode means the original request is still being processed
+type storedResponse struct {
+	requestHash string
+	statusCode  int
+	contentType string
+	headers     http.Header
+	body        []byte
+}
+
+type idempotencyKeysModel struct {
+	db  *sql.DB
+	ttl time.Duration
+}
+
+func newIdempotencyKeysModel(db *sql.DB, ttl time.Duration) idempotencyKeysModel {
+	return idempotencyKeysModel{db: db, ttl: ttl}
+}
+
+// claim reserves the key for a request with the given hash. Keys are scoped by
+// the tenant on the context, so tenants never see each other's responses. When
+// the key is already in use, claimed is false and the stored response is
+// returned
+func (model *idempotencyKeysModel) claim(ctx context.Context, key string, requestHash string) (stored storedResponse, claimed bool, err error) {
+	now := time.Now().UTC()
+
+	_, err = model.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE CreatedAt < ?", now.Add(-model.ttl))
+	if err != nil {
+		return storedResponse{}, false, err
+	}
+
+	_, err = model.db.ExecContext(ctx,
+		"INSERT INTO idempotency_keys (TenantID, IdempotencyKey, RequestHash, CreatedAt) VALUES (?, ?, ?, ?)",
+		tenantOf(ctx),
+		key,
+		requestHash,
+		now)
+	if err == nil {
+		return storedResponse{}, true, nil
+	}
+	if !isMySQLError(err, errDupEntry) {
+		return storedResponse{}, false, err
+	}
+
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var headers sql.NullString
+	err = model.db.QueryRowContext(ctx,
+		"SELECT RequestHash, StatusCode, ContentType, ResponseHeaders, ResponseBody FROM idempotency_keys WHERE TenantID=? AND IdempotencyKey=?",
+		tenantOf(ctx),
+		key).Scan(&stored.requestHash, &statusCode, &contentType, &headers, &stored.body)
+	if err != nil {
+		return storedResponse{}, false, err
+	}
+	stored.statusCode = int(statusCode.Int64)
+	stored.contentType = contentType.String
+	if headers.Valid {
+		if err := json.Unmarshal([]byte(headers.String), &stored.headers); err != nil {
+			return storedResponse{}, false, err
+		}
+	}
+	return stored, false, nil
+}
+
+// complete records the response of the request that claimed the key
+func (model *idempotencyKeysModel) complete(ctx context.Context, key string, response storedResponse) error {
+	headers, err := json.Marshal(response.headers)
+	if err != nil {
+		return err
+	}
+	_, err = model.db.ExecContext(ctx,
+		"UPDATE idempotency_keys SET StatusCode=?, ContentType=?, ResponseHeaders=?, ResponseBody=? WHERE TenantID=? AND IdempotencyKey=?",
+		response.statusCode,
+		response.contentType,
+		headers,
+		response.body,
+		tenantOf(ctx),
+		key)
+	return err
+}
+
+// release frees the key so the request can be retried
+func (model *idempotencyKeysModel) release(ctx context.Context, key string) error {
+	_, err := model.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE TenantID=? AND IdempotencyKey=?", tenantOf(ctx), key)
+	return err
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jonbern/go-example-api/pkg/pdf"
+	"net/http"
+	"strings"
+)
+
+const mediaTypePDF = "application/pdf"
+
+// invoiceDocument holds everything printed on an invoice
+type invoiceDocument struct {
+	invoice  invoice
+	customer customer
+}
+
+// invoiceTemplate lays out an invoice document as PDF. Templates are
+// registered in invoiceTemplates and selected by the PDF_TEMPLATE env variable
+type invoiceTemplate interface {
+	render(d invoiceDocument) (*pdf.Document, error)
+}
+
+var invoiceTemplates = map[string]invoiceTemplate{
+	"default": defaultInvoiceTemplate{},
+}
+
+// writeInvoicePDF responds with the invoice rendered by the configured template
+func writeInvoicePDF(w http.ResponseWriter, r *http.Request, i invoice) {
+	ctx := r.Context()
+	c, err := customerModel.getByID(ctx, i.CustomerID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	doc, err := invoiceTemplates[config.pdf.template].render(invoiceDocument{invoice: i, customer: c})
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaTypePDF)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"invoice-%d.pdf\"", i.ID))
+	w.WriteHeader(http.StatusOK)
+	if _, err := doc.WriteTo(w); err != nil {
+		logger.error(r, err)
+	}
+}
+
+// number returns the invoice number, or the ID of invoices created before
+// invoices were numbered
+func (d invoiceDocument) number() string {
+	if d.invoice.Number != "" {
+		return d.invoice.Number
+	}
+	return fmt.Sprint(d.invoice.ID)
+}
+
+// amount formats the amount in the minor units of the invoice currency
+func (d invoiceDocument) amount(amount decimal) string {
+	minorUnits, ok := currencyMinorUnits[d.invoice.Currency]
+	if !ok {
+		minorUnits = 2
+	}
+	return amount.fixed(minorUnits) + " " + d.invoice.Currency
+}
+
+// defaultInvoiceTemplate prints a plain A4 invoice, continuing the lines on
+// additional pages when needed
+type defaultInvoiceTemplate struct{}
+
+const (
+	marginLeft   = 50.0
+	marginRight  = pdf.PageWidth - 50
+	marginBottom = pdf.PageHeight - 60
+)
+
+func (t defaultInvoiceTemplate) render(d invoiceDocument) (*pdf.Document, error) {
+	i := d.invoice
+	doc := pdf.New("Invoice " + d.number())
+	page := doc.AddPage()
+
+	page.Text(marginLeft, 70, pdf.Bold, 24, "Invoice")
+	page.TextRight(marginRight, 60, pdf.Regular, 10, "Invoice number: "+d.number())
+	page.TextRight(marginRight, 74, pdf.Regular, 10, "Status: "+string(i.Status))
+	if !i.DueDate.IsZero() {
+		page.TextRight(marginRight, 88, pdf.Regular, 10, "Due date: "+i.DueDate.Format("2006-01-02"))
+	}
+
+	y := 130.0
+	page.Text(marginLeft, y, pdf.Bold, 10, "Bill to")
+	for _, s := range append([]string{d.customer.Name, d.customer.Email}, strings.Split(d.customer.Address, "\n")...) {
+		if s = strings.TrimSpace(s); s != "" {
+			y += 14
+			page.Text(marginLeft, y, pdf.Regular, 10, s)
+		}
+	}
+	if i.Description != "" {
+		y += 28
+		page.Text(marginLeft, y, pdf.Regular, 10, i.Description)
+	}
+
+	y += 36
+	tableHeader := func() {
+		page.Text(marginLeft, y, pdf.Bold, 10, "Description")
+		page.TextRight(330, y, pdf.Bold, 10, "Quantity")
+		page.TextRight(410, y, pdf.Bold, 10, "Unit price")
+		page.TextRight(460, y, pdf.Bold, 10, "Tax")
+		page.TextRight(marginRight, y, pdf.Bold, 10, "Amount")
+		page.Line(marginLeft, y+6, marginRight, y+6, 0.5)
+		y += 22
+	}
+	if len(i.Lines) > 0 {
+		tableHeader()
+	}
+	for _, l := range i.Lines {
+		if y > marginBottom {
+			page = doc.AddPage()
+			y = 60
+			tableHeader()
+		}
+		page.Text(marginLeft, y, pdf.Regular, 10, l.Description)
+		page.TextRight(330, y, pdf.Regular, 10, l.Quantity.String())
+		page.TextRight(410, y, pdf.Regular, 10, d.amount(l.UnitPrice))
+		page.TextRight(460, y, pdf.Regular, 10, l.TaxRate.mul(newDecimal(100)).String()+"%")
+		page.TextRight(marginRight, y, pdf.Regular, 10, d.amount(l.Amount))
+		y += 16
+	}
+
+	if y > marginBottom-60-16*float64(len(i.TaxSummary)) {
+		page = doc.AddPage()
+		y = 60
+	}
+	page.Line(330, y, marginRight, y, 0.5)
+	y += 18
+	if len(i.Lines) > 0 {
+		page.Text(330, y, pdf.Regular, 10, "Subtotal")
+		page.TextRight(marginRight, y, pdf.Regular, 10, d.amount(i.NetAmount))
+		y += 16
+		for _, s := range i.TaxSummary {
+			page.Text(330, y, pdf.Regular, 10, "Tax "+s.Rate.mul(newDecimal(100)).String()+"%")
+			page.TextRight(marginRight, y, pdf.Regular, 10, d.amount(s.Tax))
+			y += 16
+		}
+	}
+	page.Text(330, y, pdf.Bold, 12, "Total")
+	page.TextRight(marginRight, y, pdf.Bold, 12, d.amount(i.Amount))
+	if !i.Credited.isZero() {
+		y += 18
+		page.Text(330, y, pdf.Regular, 10, "Credited")
+		page.TextRight(marginRight, y, pdf.Regular, 10, d.amount(decimal{}.sub(i.Credited)))
+		y += 16
+		page.Text(330, y, pdf.Bold, 10, "Balance due")
+		page.TextRight(marginRight, y, pdf.Bold, 10, d.amount(i.Balance))
+	}
+
+	return doc, nil
+}
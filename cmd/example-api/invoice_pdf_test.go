@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultInvoiceTemplate(t *testing.T) {
+	lines := []invoiceLine{}
+	for n := 0; n < 60; n++ {
+		lines = append(lines, invoiceLine{Description: fmt.Sprintf("Line %d", n), Quantity: mustDecimal("2"), UnitPrice: mustDecimal("10"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("25")})
+	}
+	d := invoiceDocument{
+		invoice: invoice{
+			ID:         42,
+			DueDate:    time.Date(2019, 11, 23, 0, 0, 0, 0, time.UTC),
+			NetAmount:  mustDecimal("1200"),
+			TaxAmount:  mustDecimal("300"),
+			Amount:     mustDecimal("1500"),
+			Currency:   "NOK",
+			Status:     statusIssued,
+			Lines:      lines,
+			TaxSummary: []taxSummary{{Rate: mustDecimal("0.25"), Net: mustDecimal("1200"), Tax: mustDecimal("300"), Gross: mustDecimal("1500")}},
+		},
+		customer: customer{Name: "Acme AS", Address: "Storgata 1\n0155 Oslo"},
+	}
+
+	doc, err := defaultInvoiceTemplate{}.render(d)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var buf bytes.Buffer
+	doc.WriteTo(&buf)
+	out := buf.String()
+
+	for _, s := range []string{"Invoice number: 42", "Due date: 2019-11-23", "Acme AS", "0155 Oslo", "Line 59", "25%", "Subtotal", "Tax 25%", "1200.00 NOK", "300.00 NOK", "1500.00 NOK"} {
+		if !strings.Contains(out, "("+s+")") {
+			t.Errorf("Should print %q", s)
+		}
+	}
+	if !strings.Contains(out, "/Count 2") {
+		t.Errorf("Should continue lines on a second page")
+	}
+}
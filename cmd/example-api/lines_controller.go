@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func getInvoiceLines(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	lines, err := model.getLines(ctx, invoiceID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(lines); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func getInvoiceLine(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+	lineID, ok := intVar(w, r, "lineID")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	line, err := model.getLine(ctx, invoiceID, lineID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(line); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func createInvoiceLine(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var l invoiceLine
+	if !readJSON(w, r, &l) {
+		return
+	}
+
+	if errs := l.validate(""); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, changed, err := model.createLine(ctx, invoiceID, l, version)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(changed))
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func updateInvoiceLine(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+	lineID, ok := intVar(w, r, "lineID")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var l invoiceLine
+	if !readJSON(w, r, &l) {
+		return
+	}
+
+	if errs := l.validate(""); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	l.ID = lineID
+	l.InvoiceID = invoiceID
+
+	ctx := r.Context()
+	result, changed, err := model.updateLine(ctx, l, version)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(changed))
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func deleteInvoiceLine(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+	lineID, ok := intVar(w, r, "lineID")
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	changed, err := model.deleteLine(ctx, invoiceID, lineID, version)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", invoiceETag(changed))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// lineColNames selects the gross amount of each line, which includes tax
+// unless the unit price already does
+const lineColNames string = "ID, InvoiceID, Description, Category, Quantity, UnitPrice, TaxRate, " +
+	"ROUND(Quantity * UnitPrice * IF((SELECT TaxMode FROM invoices WHERE invoices.ID = InvoiceID) = 'inclusive', 1, 1 + TaxRate), 4)"
+
+func parseLineRow(scanFn func(...interface{}) error) (invoiceLine, error) {
+	var l invoiceLine
+	var category sql.NullString
+	if err := scanFn(
+		&l.ID,
+		&l.InvoiceID,
+		&l.Description,
+		&category,
+		&l.Quantity,
+		&l.UnitPrice,
+		&l.TaxRate,
+		&l.Amount); err != nil {
+		return invoiceLine{}, err
+	}
+	l.Category = category.String
+	return l, nil
+}
+
+func getLines(ctx context.Context, q queryer, invoiceID int) ([]invoiceLine, error) {
+	rows, err := q.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM invoice_lines WHERE InvoiceID=? ORDER BY ID", lineColNames),
+		invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []invoiceLine{}
+	for rows.Next() {
+		l, err := parseLineRow(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func getLine(ctx context.Context, q queryer, invoiceID int, ID int) (invoiceLine, error) {
+	row := q.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %v FROM invoice_lines WHERE InvoiceID=? AND ID=?", lineColNames),
+		invoiceID,
+		ID)
+	l, err := parseLineRow(row.Scan)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return invoiceLine{}, NotFoundError(fmt.Sprintf("Line with ID=%d not found on invoice with ID=%d", ID, invoiceID))
+	case err != nil:
+		return invoiceLine{}, err
+	default:
+		return l, nil
+	}
+}
+
+func insertLine(ctx context.Context, q queryer, invoiceID int, l invoiceLine) (int, error) {
+	rate, err := lineTaxRate(ctx, q, invoiceID, l)
+	if err != nil {
+		return 0, err
+	}
+	result, err := q.ExecContext(ctx,
+		"INSERT INTO invoice_lines (InvoiceID, Description, Category, Quantity, UnitPrice, TaxRate) VALUES (?, ?, ?, ?, ?, ?)",
+		invoiceID,
+		l.Description,
+		sql.NullString{String: l.Category, Valid: l.Category != ""},
+		l.Quantity,
+		l.UnitPrice,
+		rate)
+	if err != nil {
+		return 0, err
+	}
+	ID, err := result.LastInsertId()
+	return int(ID), err
+}
+
+// updateTotal sets the amount and tax of the invoice to the totals computed
+// from its lines by computeTax, in the decimal places of the invoice currency.
+// Totals that do not fit the amount column are rejected
+func updateTotal(ctx context.Context, q queryer, invoiceID int) error {
+	var currency string
+	var mode taxMode
+	if err := q.QueryRowContext(ctx, "SELECT Currency, TaxMode FROM invoices WHERE ID=?", invoiceID).Scan(&currency, &mode); err != nil {
+		return err
+	}
+	lines, err := getLines(ctx, q, invoiceID)
+	if err != nil {
+		return err
+	}
+
+	b := computeTax(lines, mode, currencyMinorUnits[currency])
+	if b.gross.cmp(maxAmount) > 0 {
+		return ValidationError{{"amount", "max", fmt.Sprintf("Total of the lines must not be greater than %v", maxAmount)}}
+	}
+	_, err = q.ExecContext(ctx, "UPDATE invoices SET Amount=?, TaxAmount=? WHERE ID=?", b.gross, b.tax, invoiceID)
+	return err
+}
+
+func (model *invoicesModel) getLines(ctx context.Context, invoiceID int) ([]invoiceLine, error) {
+	if _, err := model.getByID(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+	return getLines(ctx, model.db, invoiceID)
+}
+
+func (model *invoicesModel) getLine(ctx context.Context, invoiceID int, ID int) (invoiceLine, error) {
+	if _, err := model.getByID(ctx, invoiceID); err != nil {
+		return invoiceLine{}, err
+	}
+	return getLine(ctx, model.db, invoiceID, ID)
+}
+
+// withLineChange runs fn in a transaction holding the invoice lock, provided
+// the invoice is a draft and still has the expected version, and recomputes
+// the invoice amount, bumps its version and records the change as action
+// afterwards. The invoice is returned as changed
+func (model *invoicesModel) withLineChange(ctx context.Context, invoiceID int, expectedVersion int, action string, fn func(tx *sql.Tx) error) (invoice, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return invoice{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, invoiceID)
+	if err != nil {
+		return invoice{}, err
+	}
+	if err := checkVersion(existing, expectedVersion); err != nil {
+		return invoice{}, err
+	}
+	if existing.Status != statusDraft {
+		return invoice{}, ConflictError(fmt.Sprintf("Invoice with ID=%d is %v, only the lines of drafts can change", invoiceID, existing.Status))
+	}
+	if err := fn(tx); err != nil {
+		return invoice{}, err
+	}
+	if err := updateTotal(ctx, tx, invoiceID); err != nil {
+		return invoice{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET Version=Version+1 WHERE ID=?", invoiceID); err != nil {
+		return invoice{}, err
+	}
+	if err := audit(ctx, tx, action, invoiceID, &existing); err != nil {
+		return invoice{}, err
+	}
+	changed, err := queryInvoice(ctx, tx, invoiceID, false, "")
+	if err != nil {
+		return invoice{}, err
+	}
+	return changed, tx.Commit()
+}
+
+// createLine adds the line to the invoice and returns it along with the
+// changed invoice
+func (model *invoicesModel) createLine(ctx context.Context, invoiceID int, l invoiceLine, expectedVersion int) (invoiceLine, invoice, error) {
+	var ID int
+	changed, err := model.withLineChange(ctx, invoiceID, expectedVersion, actionCreateLine, func(tx *sql.Tx) error {
+		var err error
+		ID, err = insertLine(ctx, tx, invoiceID, l)
+		return err
+	})
+	if err != nil {
+		return invoiceLine{}, invoice{}, err
+	}
+	created, err := model.getLine(ctx, invoiceID, ID)
+	return created, changed, err
+}
+
+// updateLine replaces the line and returns it along with the changed invoice
+func (model *invoicesModel) updateLine(ctx context.Context, l invoiceLine, expectedVersion int) (invoiceLine, invoice, error) {
+	changed, err := model.withLineChange(ctx, l.InvoiceID, expectedVersion, actionUpdateLine, func(tx *sql.Tx) error {
+		if _, err := getLine(ctx, tx, l.InvoiceID, l.ID); err != nil {
+			return err
+		}
+		rate, err := lineTaxRate(ctx, tx, l.InvoiceID, l)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			"UPDATE invoice_lines SET Description=?, Category=?, Quantity=?, UnitPrice=?, TaxRate=? WHERE ID=?",
+			l.Description,
+			sql.NullString{String: l.Category, Valid: l.Category != ""},
+			l.Quantity,
+			l.UnitPrice,
+			rate,
+			l.ID)
+		return err
+	})
+	if err != nil {
+		return invoiceLine{}, invoice{}, err
+	}
+	updated, err := model.getLine(ctx, l.InvoiceID, l.ID)
+	return updated, changed, err
+}
+
+// deleteLine removes the line and returns the changed invoice
+func (model *invoicesModel) deleteLine(ctx context.Context, invoiceID int, ID int, expectedVersion int) (invoice, error) {
+	return model.withLineChange(ctx, invoiceID, expectedVersion, actionDeleteLine, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM invoice_lines WHERE InvoiceID=? AND ID=?", invoiceID, ID)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return NotFoundError(fmt.Sprintf("Line with ID=%d not found on invoice with ID=%d", ID, invoiceID))
+		}
+		return nil
+	})
+}
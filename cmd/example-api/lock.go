@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// withAdvisoryLock runs fn while holding the named MySQL advisory lock, so
+// only one replica runs fn at a time. When another replica holds the lock fn
+// is not run and locked is false
+func withAdvisoryLock(ctx context.Context, db *sql.DB, name string, fn func(conn *sql.Conn) error) (locked bool, err error) {
+	// Advisory locks belong to a connection, so one is reserved for the run
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", name).Scan(&acquired); err != nil {
+		return false, err
+	}
+	if acquired.Int64 != 1 {
+		return false, nil
+	}
+	defer conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", name).Scan(&acquired)
+
+	return true, fn(conn)
+}
@@ -16,15 +16,25 @@ import (
 
 var logger requestLogger = requestLogger{}
 var model invoicesModel
+var customerModel customersModel
+var taxRateModel taxRatesModel
+var scheduleModel schedulesModel
+var idempotencyKeyModel idempotencyKeysModel
 
 var config conf = newConfig()
 
-const schemaVersion = 1
+const schemaVersion = 20
 
 func main() {
 	config := newConfig()
 	router := newAPI(config.db.name)
 	go heartBeat()
+	if config.overdue.interval > 0 {
+		go overdueJob(config.overdue.interval)
+	}
+	if config.generator.interval > 0 {
+		go generatorJob(config.generator.interval)
+	}
 	log.Println(fmt.Sprintf("Listening to request on port=%v", config.port))
 	log.Fatal(http.ListenAndServe(":"+config.port, router))
 }
@@ -47,7 +57,13 @@ func newAPI(dbName string) *mux.Router {
 	if err != nil {
 		log.Panic(err)
 	}
-	m.Steps(schemaVersion)
+	if err := m.Migrate(schemaVersion); err != nil && err != migrate.ErrNoChange {
+		log.Panic(err)
+	}
+
+	if _, ok := invoiceTemplates[config.pdf.template]; !ok {
+		log.Panic(fmt.Sprintf("Unknown PDF_TEMPLATE=%q", config.pdf.template))
+	}
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -59,6 +75,10 @@ func newAPI(dbName string) *mux.Router {
 	}
 
 	model = newInvoicesModel(db)
+	customerModel = newCustomersModel(db)
+	taxRateModel = newTaxRatesModel(db)
+	scheduleModel = newSchedulesModel(db)
+	idempotencyKeyModel = newIdempotencyKeysModel(db, config.idempotency.ttl)
 
 	router := mux.NewRouter().StrictSlash(true)
 	router.Use(ensureCorrelationID)
@@ -75,14 +95,214 @@ func newAPI(dbName string) *mux.Router {
 		HandlerFunc(checkPermission(getInvoices, "getInvoices"))
 	router.Methods(http.MethodPost).
 		Path("/invoices").
-		HandlerFunc(checkPermission(createInvoice, "createInvoice"))
+		HandlerFunc(checkPermission(idempotent(createInvoice), "createInvoice"))
 
 	router.Methods(http.MethodOptions).
-		Path("/invoices/{id}").
+		Path("/invoices:bulk").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/invoices:bulk").
+		HandlerFunc(checkPermission(bulkCreateInvoices, "createInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/search").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/search").
+		HandlerFunc(checkPermission(searchInvoices, "getInvoices"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/by-number/{number}").
 		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/by-number/{number}").
+		HandlerFunc(checkPermission(getInvoiceByNumber, "getInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}").
+		HandlerFunc(optionsResponse("GET,PUT,PATCH,DELETE,OPTIONS"))
 	router.Methods(http.MethodGet).
 		Path("/invoices/{id}").
 		HandlerFunc(checkPermission(getInvoice, "getInvoice"))
+	router.Methods(http.MethodPut).
+		Path("/invoices/{id}").
+		HandlerFunc(checkPermission(updateInvoice, "updateInvoice"))
+	router.Methods(http.MethodPatch).
+		Path("/invoices/{id}").
+		HandlerFunc(checkPermission(patchInvoice, "updateInvoice"))
+	router.Methods(http.MethodDelete).
+		Path("/invoices/{id}").
+		HandlerFunc(checkPermission(deleteInvoice, "deleteInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/payments").
+		HandlerFunc(optionsResponse("GET,POST,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/{id}/payments").
+		HandlerFunc(checkPermission(getInvoicePayments, "getInvoice"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/payments").
+		HandlerFunc(checkPermission(createInvoicePayment, "createPayment"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/credit-notes").
+		HandlerFunc(optionsResponse("GET,POST,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/{id}/credit-notes").
+		HandlerFunc(checkPermission(getInvoiceCreditNotes, "getInvoice"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/credit-notes").
+		HandlerFunc(checkPermission(createInvoiceCreditNote, "createCreditNote"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/history").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/{id}/history").
+		HandlerFunc(checkPermission(getInvoiceHistory, "getInvoiceHistory"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/restore").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/restore").
+		HandlerFunc(checkPermission(restoreInvoice, "restoreInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/lines").
+		HandlerFunc(optionsResponse("GET,POST,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/{id}/lines").
+		HandlerFunc(checkPermission(getInvoiceLines, "getInvoice"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/lines").
+		HandlerFunc(checkPermission(createInvoiceLine, "updateInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/lines/{lineID}").
+		HandlerFunc(optionsResponse("GET,PUT,DELETE,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/invoices/{id}/lines/{lineID}").
+		HandlerFunc(checkPermission(getInvoiceLine, "getInvoice"))
+	router.Methods(http.MethodPut).
+		Path("/invoices/{id}/lines/{lineID}").
+		HandlerFunc(checkPermission(updateInvoiceLine, "updateInvoice"))
+	router.Methods(http.MethodDelete).
+		Path("/invoices/{id}/lines/{lineID}").
+		HandlerFunc(checkPermission(deleteInvoiceLine, "updateInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/issue").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/issue").
+		HandlerFunc(checkPermission(transitionInvoice(statusIssued), "issueInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/pay").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/pay").
+		HandlerFunc(checkPermission(transitionInvoice(statusPaid), "payInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/invoices/{id}/void").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/invoices/{id}/void").
+		HandlerFunc(checkPermission(transitionInvoice(statusVoid), "voidInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/credit-notes/{id}").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/credit-notes/{id}").
+		HandlerFunc(checkPermission(getCreditNote, "getInvoice"))
+
+	router.Methods(http.MethodOptions).
+		Path("/customers").
+		HandlerFunc(optionsResponse("GET,POST,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/customers").
+		HandlerFunc(checkPermission(getCustomers, "getCustomers"))
+	router.Methods(http.MethodPost).
+		Path("/customers").
+		HandlerFunc(checkPermission(createCustomer, "createCustomer"))
+
+	router.Methods(http.MethodOptions).
+		Path("/customers/{id}").
+		HandlerFunc(optionsResponse("GET,PUT,DELETE,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/customers/{id}").
+		HandlerFunc(checkPermission(getCustomer, "getCustomer"))
+	router.Methods(http.MethodPut).
+		Path("/customers/{id}").
+		HandlerFunc(checkPermission(updateCustomer, "updateCustomer"))
+	router.Methods(http.MethodDelete).
+		Path("/customers/{id}").
+		HandlerFunc(checkPermission(deleteCustomer, "deleteCustomer"))
+
+	router.Methods(http.MethodOptions).
+		Path("/customers/{id}/invoices").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/customers/{id}/invoices").
+		HandlerFunc(checkPermission(getCustomerInvoices, "getInvoices"))
+
+	router.Methods(http.MethodOptions).
+		Path("/tax-rates").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/tax-rates").
+		HandlerFunc(checkPermission(getTaxRates, "getTaxRates"))
+
+	router.Methods(http.MethodOptions).
+		Path("/tax-rates/{jurisdiction}/{category}").
+		HandlerFunc(optionsResponse("PUT,OPTIONS"))
+	router.Methods(http.MethodPut).
+		Path("/tax-rates/{jurisdiction}/{category}").
+		HandlerFunc(checkPermission(putTaxRate, "updateTaxRates"))
+
+	router.Methods(http.MethodOptions).
+		Path("/schedules").
+		HandlerFunc(optionsResponse("GET,POST,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/schedules").
+		HandlerFunc(checkPermission(getSchedules, "getSchedules"))
+	router.Methods(http.MethodPost).
+		Path("/schedules").
+		HandlerFunc(checkPermission(createSchedule, "updateSchedules"))
+
+	router.Methods(http.MethodOptions).
+		Path("/schedules/{id}").
+		HandlerFunc(optionsResponse("GET,DELETE,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/schedules/{id}").
+		HandlerFunc(checkPermission(getSchedule, "getSchedules"))
+	router.Methods(http.MethodDelete).
+		Path("/schedules/{id}").
+		HandlerFunc(checkPermission(deleteSchedule, "updateSchedules"))
+
+	router.Methods(http.MethodOptions).
+		Path("/schedules/{id}/preview").
+		HandlerFunc(optionsResponse("GET,OPTIONS"))
+	router.Methods(http.MethodGet).
+		Path("/schedules/{id}/preview").
+		HandlerFunc(checkPermission(previewSchedule, "getSchedules"))
+
+	router.Methods(http.MethodOptions).
+		Path("/schedules/{id}/pause").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/schedules/{id}/pause").
+		HandlerFunc(checkPermission(pauseSchedule(true), "updateSchedules"))
+
+	router.Methods(http.MethodOptions).
+		Path("/schedules/{id}/resume").
+		HandlerFunc(optionsResponse("POST,OPTIONS"))
+	router.Methods(http.MethodPost).
+		Path("/schedules/{id}/resume").
+		HandlerFunc(checkPermission(pauseSchedule(false), "updateSchedules"))
 
 	router.PathPrefix("/").HandlerFunc(notFoundHandler)
 	return router
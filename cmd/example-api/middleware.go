@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"net/http"
 )
 
+type correlationIDContextKey string
+
+var ctxKeyCorrelationID correlationIDContextKey = correlationIDContextKey("correlationID")
+
 func ensureCorrelationID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		correlationID := r.Header.Get("X-Correlation-ID")
@@ -13,7 +18,8 @@ func ensureCorrelationID(next http.Handler) http.Handler {
 			r.Header.Set("X-Correlation-ID", correlationID)
 		}
 		w.Header().Set("X-Correlation-ID", r.Header.Get("X-Correlation-ID"))
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ctxKeyCorrelationID, correlationID)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
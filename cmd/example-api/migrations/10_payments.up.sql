@@ -0,0 +1,10 @@
+CREATE TABLE `payments` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `InvoiceID` int(10) unsigned NOT NULL,
+  `Amount` decimal(12,4) NOT NULL,
+  `ReceivedAt` datetime NOT NULL,
+  `Reference` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`ID`),
+  KEY `InvoiceID` (`InvoiceID`),
+  CONSTRAINT `payments_invoices` FOREIGN KEY (`InvoiceID`) REFERENCES `invoices` (`ID`)
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
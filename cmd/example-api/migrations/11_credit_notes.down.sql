@@ -0,0 +1,2 @@
+DROP TABLE `credit_notes`;
+DROP TABLE `number_sequences`;
\ No newline at end of file
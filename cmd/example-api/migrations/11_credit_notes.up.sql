@@ -0,0 +1,20 @@
+CREATE TABLE `number_sequences` (
+  `Name` varchar(50) NOT NULL,
+  `NextValue` int(10) unsigned NOT NULL,
+  PRIMARY KEY (`Name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO `number_sequences` (`Name`, `NextValue`) VALUES ('creditNote', 1);
+
+CREATE TABLE `credit_notes` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `Number` varchar(50) NOT NULL,
+  `InvoiceID` int(10) unsigned NOT NULL,
+  `Amount` decimal(12,4) NOT NULL,
+  `Reason` varchar(255) DEFAULT NULL,
+  `CreatedAt` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `Number` (`Number`),
+  KEY `InvoiceID` (`InvoiceID`),
+  CONSTRAINT `credit_notes_invoices` FOREIGN KEY (`InvoiceID`) REFERENCES `invoices` (`ID`)
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE `invoices`
+  DROP KEY `Number`,
+  DROP COLUMN `Number`;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `Number` varchar(50) DEFAULT NULL AFTER `ID`,
+  ADD UNIQUE KEY `Number` (`Number`);
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE `invoice_lines`
+  DROP COLUMN `Category`;
+
+ALTER TABLE `invoices`
+  DROP COLUMN `TaxAmount`,
+  DROP COLUMN `Jurisdiction`,
+  DROP COLUMN `TaxMode`;
+
+DROP TABLE `tax_rates`;
\ No newline at end of file
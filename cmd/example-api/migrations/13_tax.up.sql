@@ -0,0 +1,35 @@
+CREATE TABLE `tax_rates` (
+  `Jurisdiction` varchar(10) NOT NULL,
+  `Category` varchar(50) NOT NULL,
+  `Rate` decimal(5,4) NOT NULL,
+  PRIMARY KEY (`Jurisdiction`, `Category`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO `tax_rates` (`Jurisdiction`, `Category`, `Rate`) VALUES
+  ('NO', 'standard', 0.25),
+  ('NO', 'food', 0.15),
+  ('NO', 'transport', 0.12),
+  ('NO', 'exempt', 0),
+  ('SE', 'standard', 0.25),
+  ('SE', 'food', 0.12),
+  ('SE', 'books', 0.06),
+  ('SE', 'exempt', 0),
+  ('DK', 'standard', 0.25),
+  ('DK', 'exempt', 0),
+  ('DE', 'standard', 0.19),
+  ('DE', 'reduced', 0.07),
+  ('DE', 'exempt', 0);
+
+ALTER TABLE `invoices`
+  ADD COLUMN `TaxMode` varchar(10) NOT NULL DEFAULT 'exclusive',
+  ADD COLUMN `Jurisdiction` varchar(10) DEFAULT NULL,
+  ADD COLUMN `TaxAmount` decimal(12,4) NOT NULL DEFAULT 0;
+
+-- Invoices with lines already include tax in their amount, which is split
+-- out so that the net amount and tax add up to the stored amount
+UPDATE `invoices` SET `TaxAmount` = `Amount` - (
+  SELECT ROUND(SUM(`Quantity` * `UnitPrice`), 4) FROM `invoice_lines` WHERE `invoice_lines`.`InvoiceID` = `invoices`.`ID`
+) WHERE EXISTS (SELECT 1 FROM `invoice_lines` WHERE `invoice_lines`.`InvoiceID` = `invoices`.`ID`);
+
+ALTER TABLE `invoice_lines`
+  ADD COLUMN `Category` varchar(50) DEFAULT NULL AFTER `Description`;
\ No newline at end of file
@@ -0,0 +1,25 @@
+-- Only the sequences of the default tenant can be kept, and the invoices and
+-- credit notes of other tenants must not reuse numbers of the default tenant
+DELETE FROM `number_sequences`
+  WHERE (`Name` LIKE 'invoice:%' AND `Name` NOT LIKE 'invoice:default:%')
+  OR (`Name` LIKE 'creditNote:%' AND `Name` <> 'creditNote:default');
+UPDATE `number_sequences` SET `Name` = CONCAT('invoice:', SUBSTRING(`Name`, 17)) WHERE `Name` LIKE 'invoice:default:%';
+UPDATE `number_sequences` SET `Name` = 'creditNote' WHERE `Name` = 'creditNote:default';
+ALTER TABLE `number_sequences`
+  MODIFY COLUMN `Name` varchar(50) NOT NULL;
+
+ALTER TABLE `idempotency_keys`
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`IdempotencyKey`),
+  DROP COLUMN `TenantID`;
+
+ALTER TABLE `credit_notes`
+  DROP KEY `Number`,
+  ADD UNIQUE KEY `Number` (`Number`),
+  DROP COLUMN `TenantID`;
+
+ALTER TABLE `invoices`
+  DROP KEY `TenantID`,
+  DROP KEY `Number`,
+  ADD UNIQUE KEY `Number` (`Number`),
+  DROP COLUMN `TenantID`;
\ No newline at end of file
@@ -0,0 +1,28 @@
+-- Rows created before tenants existed belong to the default tenant
+ALTER TABLE `invoices`
+  ADD COLUMN `TenantID` varchar(64) NOT NULL DEFAULT 'default' AFTER `ID`,
+  DROP KEY `Number`,
+  ADD UNIQUE KEY `Number` (`TenantID`, `Number`),
+  ADD KEY `TenantID` (`TenantID`, `ID`);
+ALTER TABLE `invoices`
+  ALTER COLUMN `TenantID` DROP DEFAULT;
+
+ALTER TABLE `credit_notes`
+  ADD COLUMN `TenantID` varchar(64) NOT NULL DEFAULT 'default' AFTER `ID`,
+  DROP KEY `Number`,
+  ADD UNIQUE KEY `Number` (`TenantID`, `Number`);
+ALTER TABLE `credit_notes`
+  ALTER COLUMN `TenantID` DROP DEFAULT;
+
+ALTER TABLE `idempotency_keys`
+  ADD COLUMN `TenantID` varchar(64) NOT NULL DEFAULT 'default' FIRST,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`TenantID`, `IdempotencyKey`);
+ALTER TABLE `idempotency_keys`
+  ALTER COLUMN `TenantID` DROP DEFAULT;
+
+-- Every tenant numbers invoices and credit notes separately
+ALTER TABLE `number_sequences`
+  MODIFY COLUMN `Name` varchar(150) NOT NULL;
+UPDATE `number_sequences` SET `Name` = CONCAT('invoice:default:', SUBSTRING(`Name`, 9)) WHERE `Name` LIKE 'invoice:%';
+UPDATE `number_sequences` SET `Name` = 'creditNote:default' WHERE `Name` = 'creditNote';
\ No newline at end of file
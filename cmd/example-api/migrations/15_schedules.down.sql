@@ -0,0 +1,7 @@
+ALTER TABLE `invoices`
+  DROP FOREIGN KEY `invoices_schedules`,
+  DROP KEY `ScheduledRun`,
+  DROP COLUMN `ScheduledFor`,
+  DROP COLUMN `ScheduleID`;
+
+DROP TABLE `schedules`;
\ No newline at end of file
@@ -0,0 +1,34 @@
+CREATE TABLE `schedules` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `TenantID` varchar(64) NOT NULL,
+  `CustomerID` int(10) unsigned NOT NULL,
+  `Description` varchar(45) DEFAULT NULL,
+  `Amount` decimal(12,4) NOT NULL,
+  `Currency` char(3) NOT NULL,
+  `TaxMode` varchar(10) NOT NULL,
+  `Jurisdiction` varchar(10) DEFAULT NULL,
+  `Series` varchar(30) DEFAULT NULL,
+  `LineItems` mediumtext DEFAULT NULL,
+  `Every` int(10) unsigned DEFAULT NULL,
+  `Unit` varchar(10) DEFAULT NULL,
+  `Cron` varchar(100) DEFAULT NULL,
+  `Anchor` datetime NOT NULL,
+  `DueDays` int(10) unsigned NOT NULL,
+  `Issue` tinyint(1) NOT NULL,
+  `CatchUp` varchar(10) NOT NULL,
+  `Paused` tinyint(1) NOT NULL DEFAULT 0,
+  `NextRunAt` datetime NOT NULL,
+  `LastRunAt` datetime DEFAULT NULL,
+  PRIMARY KEY (`ID`),
+  KEY `TenantID` (`TenantID`, `ID`),
+  KEY `NextRunAt` (`Paused`, `NextRunAt`),
+  CONSTRAINT `schedules_customers` FOREIGN KEY (`CustomerID`) REFERENCES `customers` (`ID`)
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
+
+-- Each run of a schedule creates at most one invoice, even when the generator
+-- is interrupted and repeats the run
+ALTER TABLE `invoices`
+  ADD COLUMN `ScheduleID` int(10) unsigned DEFAULT NULL,
+  ADD COLUMN `ScheduledFor` datetime DEFAULT NULL,
+  ADD UNIQUE KEY `ScheduledRun` (`ScheduleID`, `ScheduledFor`),
+  ADD CONSTRAINT `invoices_schedules` FOREIGN KEY (`ScheduleID`) REFERENCES `schedules` (`ID`) ON DELETE SET NULL;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE `invoice_lines`
+  DROP KEY `DescriptionSearch`;
+
+ALTER TABLE `invoices`
+  DROP KEY `DescriptionSearch`;
\ No newline at end of file
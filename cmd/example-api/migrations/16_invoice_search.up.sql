@@ -0,0 +1,5 @@
+ALTER TABLE `invoices`
+  ADD FULLTEXT KEY `DescriptionSearch` (`Description`);
+
+ALTER TABLE `invoice_lines`
+  ADD FULLTEXT KEY `DescriptionSearch` (`Description`);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `idempotency_keys`
+  ADD COLUMN `ResponseHeaders` text DEFAULT NULL AFTER `ContentType`;
\ No newline at end of file
@@ -0,0 +1,10 @@
+-- Only the shared default rates can be kept
+DELETE FROM `tax_rates` WHERE `TenantID` <> '';
+ALTER TABLE `tax_rates`
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`Jurisdiction`, `Category`),
+  DROP COLUMN `TenantID`;
+
+ALTER TABLE `customers`
+  DROP KEY `TenantID`,
+  DROP COLUMN `TenantID`;
\ No newline at end of file
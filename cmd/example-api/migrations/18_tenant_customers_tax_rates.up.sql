@@ -0,0 +1,22 @@
+-- Customers created before they were scoped to tenants belong to the tenant
+-- of their invoices, or to the default tenant when they have none or are
+-- invoiced by several tenants
+ALTER TABLE `customers`
+  ADD COLUMN `TenantID` varchar(64) NOT NULL DEFAULT 'default' AFTER `ID`,
+  ADD KEY `TenantID` (`TenantID`, `ID`);
+UPDATE `customers` SET `TenantID` = (
+  SELECT MIN(`TenantID`) FROM `invoices` WHERE `invoices`.`CustomerID` = `customers`.`ID`
+) WHERE (
+  SELECT COUNT(DISTINCT `TenantID`) FROM `invoices` WHERE `invoices`.`CustomerID` = `customers`.`ID`
+) = 1;
+ALTER TABLE `customers`
+  ALTER COLUMN `TenantID` DROP DEFAULT;
+
+-- Existing rates become the defaults shared by every tenant, which tenants
+-- override with rates of their own
+ALTER TABLE `tax_rates`
+  ADD COLUMN `TenantID` varchar(64) NOT NULL DEFAULT '' FIRST,
+  DROP PRIMARY KEY,
+  ADD PRIMARY KEY (`TenantID`, `Jurisdiction`, `Category`);
+ALTER TABLE `tax_rates`
+  ALTER COLUMN `TenantID` DROP DEFAULT;
\ No newline at end of file
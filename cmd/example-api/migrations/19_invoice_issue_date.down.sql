@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  DROP COLUMN `IssuedAt`;
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `IssuedAt` datetime DEFAULT NULL AFTER `DueDate`;
+
+-- Invoices issued before the issue date was stored take the date of the
+-- transition recorded in the audit log
+UPDATE `invoices` SET `IssuedAt` = (
+  SELECT MIN(`CreatedAt`) FROM `audit_events`
+  WHERE `audit_events`.`InvoiceID` = `invoices`.`ID` AND `Action` = 'transition' AND `After` LIKE '%"status":"issued"%'
+);
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- NULL is a valid due date for every earlier schema, so nothing is reverted
+DO 0;
\ No newline at end of file
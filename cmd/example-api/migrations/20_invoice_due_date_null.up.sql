@@ -0,0 +1,3 @@
+-- Invoices without a due date were stored with a zero date, which sorts and
+-- pages differently from NULL
+UPDATE `invoices` SET `DueDate` = NULL WHERE `DueDate` < '1000-01-01';
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  DROP COLUMN `Status`;
\ No newline at end of file
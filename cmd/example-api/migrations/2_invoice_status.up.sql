@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `Status` varchar(10) NOT NULL DEFAULT 'draft';
\ No newline at end of file
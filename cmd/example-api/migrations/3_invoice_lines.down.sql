@@ -0,0 +1 @@
+DROP TABLE `invoice_lines`;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE `invoice_lines` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `InvoiceID` int(10) unsigned NOT NULL,
+  `Description` varchar(255) NOT NULL,
+  `Quantity` decimal(12,4) NOT NULL,
+  `UnitPrice` decimal(12,4) NOT NULL,
+  `TaxRate` decimal(5,4) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`ID`),
+  KEY `InvoiceID` (`InvoiceID`),
+  CONSTRAINT `invoice_lines_invoices` FOREIGN KEY (`InvoiceID`) REFERENCES `invoices` (`ID`) ON DELETE CASCADE
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  DROP COLUMN `Currency`;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `Currency` char(3) NOT NULL DEFAULT 'NOK' AFTER `Amount`;
\ No newline at end of file
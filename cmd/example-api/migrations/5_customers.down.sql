@@ -0,0 +1,4 @@
+ALTER TABLE `invoices`
+  DROP FOREIGN KEY `invoices_customers`;
+
+DROP TABLE `customers`;
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE TABLE `customers` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `Name` varchar(255) NOT NULL,
+  `Email` varchar(255) DEFAULT NULL,
+  `Address` varchar(255) DEFAULT NULL,
+  PRIMARY KEY (`ID`)
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
+
+-- Create placeholder customers for invoices created before customers existed,
+-- keeping their IDs (including 0) so the foreign key can be added
+SET SESSION sql_mode = CONCAT(@@sql_mode, ',NO_AUTO_VALUE_ON_ZERO');
+INSERT INTO `customers` (`ID`, `Name`)
+  SELECT DISTINCT `CustomerID`, CONCAT('Customer ', `CustomerID`) FROM `invoices`;
+
+ALTER TABLE `invoices`
+  ADD CONSTRAINT `invoices_customers` FOREIGN KEY (`CustomerID`) REFERENCES `customers` (`ID`);
\ No newline at end of file
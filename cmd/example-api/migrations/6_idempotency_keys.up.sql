@@ -0,0 +1,10 @@
+CREATE TABLE `idempotency_keys` (
+  `IdempotencyKey` varchar(255) NOT NULL,
+  `RequestHash` char(64) NOT NULL,
+  `StatusCode` smallint(5) unsigned DEFAULT NULL,
+  `ContentType` varchar(255) DEFAULT NULL,
+  `ResponseBody` mediumblob DEFAULT NULL,
+  `CreatedAt` datetime NOT NULL,
+  PRIMARY KEY (`IdempotencyKey`),
+  KEY `CreatedAt` (`CreatedAt`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  DROP COLUMN `Version`;
\ No newline at end of file
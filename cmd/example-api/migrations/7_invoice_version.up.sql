@@ -0,0 +1,2 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `Version` int(10) unsigned NOT NULL DEFAULT 1;
\ No newline at end of file
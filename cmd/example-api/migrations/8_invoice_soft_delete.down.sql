@@ -0,0 +1,3 @@
+ALTER TABLE `invoices`
+  DROP KEY `DeletedAt`,
+  DROP COLUMN `DeletedAt`;
\ No newline at end of file
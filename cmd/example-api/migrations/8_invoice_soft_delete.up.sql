@@ -0,0 +1,3 @@
+ALTER TABLE `invoices`
+  ADD COLUMN `DeletedAt` datetime DEFAULT NULL,
+  ADD KEY `DeletedAt` (`DeletedAt`);
\ No newline at end of file
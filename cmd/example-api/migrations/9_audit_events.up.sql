@@ -0,0 +1,14 @@
+CREATE TABLE `audit_events` (
+  `ID` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `InvoiceID` int(10) unsigned NOT NULL,
+  `Action` varchar(20) NOT NULL,
+  `Actor` varchar(255) NOT NULL,
+  `CorrelationID` varchar(255) DEFAULT NULL,
+  `Version` int(10) unsigned NOT NULL,
+  `Before` mediumtext DEFAULT NULL,
+  `After` mediumtext NOT NULL,
+  `CreatedAt` datetime NOT NULL,
+  PRIMARY KEY (`ID`),
+  KEY `InvoiceID` (`InvoiceID`),
+  CONSTRAINT `audit_events_invoices` FOREIGN KEY (`InvoiceID`) REFERENCES `invoices` (`ID`)
+) ENGINE=InnoDB AUTO_INCREMENT=0 DEFAULT CHARSET=utf8mb4;
\ No newline at end of file
@@ -5,54 +5,154 @@ import (
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql" //go-lint-ignore
-	"log"
+	"time"
 )
 
-const colNames string = "ID, CustomerID, DueDate, Amount, Description"
+const colNames string = "ID, Number, CustomerID, DueDate, Amount, Currency, Description, Status, Version, DeletedAt, TaxMode, Jurisdiction, TaxAmount, IssuedAt, " +
+	creditedCol + ", Amount - " + creditedCol + " - (SELECT IFNULL(SUM(p.Amount), 0) FROM payments p WHERE p.InvoiceID = invoices.ID)"
+
+// creditedCol selects the sum of the credit notes of each invoice
+const creditedCol string = "(SELECT IFNULL(SUM(c.Amount), 0) FROM credit_notes c WHERE c.InvoiceID = invoices.ID)"
 
 type invoicesModel struct {
 	db *sql.DB
 }
 
+// queryer is implemented by both *sql.DB and *sql.Tx, allowing queries to
+// run either standalone or as part of a transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 func newInvoicesModel(db *sql.DB) invoicesModel {
 	return invoicesModel{db: db}
 }
 
+// create inserts the invoice along with its lines. When lines are given the
+// invoice amount is computed from them
 func (model *invoicesModel) create(ctx context.Context, i invoice) (invoice, error) {
-	result, err := model.db.ExecContext(ctx,
-		"INSERT INTO invoices (CustomerID, DueDate, Amount, Description) VALUES (?, ?, ?, ?)",
-		i.CustomerID,
-		i.DueDate,
-		i.Amount,
-		i.Description)
-
+	tx, err := model.db.BeginTx(ctx, nil)
 	if err != nil {
 		return invoice{}, err
 	}
-	ID, err := result.LastInsertId()
+	defer tx.Rollback()
+
+	ID, err := insertInvoice(ctx, tx, i)
 	if err != nil {
 		return invoice{}, err
 	}
+	if err := tx.Commit(); err != nil {
+		return invoice{}, err
+	}
 
-	fmt.Println(fmt.Sprintf("ID=%v", ID))
+	return model.getByID(ctx, ID)
+}
+
+// insertInvoice inserts the invoice and its lines in tx and records the
+// creation in the audit log. The invoice number is taken from the series of
+// the invoice within tx, so no number is lost if tx is rolled back
+func insertInvoice(ctx context.Context, tx *sql.Tx, i invoice) (int, error) {
+	name := i.Series
+	if name == "" {
+		name = defaultNumberSeries
+	}
+	series, ok := config.numbering.series[name]
+	if !ok {
+		return 0, ValidationError{{"series", "unknown", fmt.Sprintf("Number series %q is not configured", name)}}
+	}
+	if err := checkCustomer(ctx, tx, i.CustomerID); err != nil {
+		return 0, err
+	}
+	tenant := tenantOf(ctx)
+	year := time.Now().UTC().Year()
+	n, err := nextNumber(ctx, tx, series.sequence(tenant, name, year))
+	if err != nil {
+		return 0, err
+	}
+
+	i = withTaxDefaults(i)
+	var scheduleID sql.NullInt64
+	var scheduledFor sql.NullTime
+	if i.Run != nil {
+		scheduleID = sql.NullInt64{Int64: int64(i.Run.ScheduleID), Valid: true}
+		scheduledFor = sql.NullTime{Time: i.Run.At, Valid: true}
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO invoices (TenantID, Number, CustomerID, DueDate, Amount, Currency, Description, TaxMode, Jurisdiction, ScheduleID, ScheduledFor) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tenant,
+		series.format(n, year),
+		i.CustomerID,
+		sql.NullTime{Time: i.DueDate, Valid: !i.DueDate.IsZero()},
+		i.Amount,
+		i.Currency,
+		i.Description,
+		i.TaxMode,
+		i.Jurisdiction,
+		scheduleID,
+		scheduledFor)
+
+	if isMySQLError(err, errNoReferencedRow) {
+		return 0, ReferenceError(fmt.Sprintf("Customer with ID=%d not found", i.CustomerID))
+	}
+	if err != nil {
+		return 0, err
+	}
+	lastInsertID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	ID := int(lastInsertID)
 
-	return model.getByID(ctx, int(ID))
+	for _, l := range i.Lines {
+		if _, err := insertLine(ctx, tx, ID, l); err != nil {
+			return 0, err
+		}
+	}
+	if len(i.Lines) > 0 {
+		if err := updateTotal(ctx, tx, ID); err != nil {
+			return 0, err
+		}
+	}
+	if err := audit(ctx, tx, actionCreate, ID, nil); err != nil {
+		return 0, err
+	}
+	return ID, nil
 }
 
 func parseRow(scanFn func(...interface{}) error) (invoice, error) {
 	var i invoice = invoice{}
+	var number sql.NullString
+	var jurisdiction sql.NullString
 	var description sql.NullString
 	var dueDate sql.NullTime
+	var deletedAt sql.NullTime
+	var issuedAt sql.NullTime
 
 	if err := scanFn(
 		&i.ID,
+		&number,
 		&i.CustomerID,
 		&dueDate,
 		&i.Amount,
-		&description); err != nil {
+		&i.Currency,
+		&description,
+		&i.Status,
+		&i.Version,
+		&deletedAt,
+		&i.TaxMode,
+		&jurisdiction,
+		&i.TaxAmount,
+		&issuedAt,
+		&i.Credited,
+		&i.Balance); err != nil {
 		return invoice{}, err
 	}
 
+	i.Number = number.String
+	i.Jurisdiction = jurisdiction.String
+	i.NetAmount = i.Amount.sub(i.TaxAmount)
 	if dueDate.Valid {
 		i.DueDate = dueDate.Time
 	}
@@ -60,13 +160,26 @@ func parseRow(scanFn func(...interface{}) error) (invoice, error) {
 	if description.Valid {
 		i.Description = description.String
 	}
+
+	if issuedAt.Valid {
+		i.IssuedAt = &issuedAt.Time
+	}
+
+	if deletedAt.Valid {
+		i.DeletedAt = &deletedAt.Time
+	}
 	return i, nil
 }
 
-func (model *invoicesModel) getAll(ctx context.Context) ([]invoice, error) {
-	rows, err := model.db.QueryContext(ctx, fmt.Sprintf("SELECT %v FROM invoices", colNames))
+// getPage returns the page of invoices matching the filters of q in the
+// requested sort order
+func (model *invoicesModel) getPage(ctx context.Context, q invoiceQuery) (invoicePage, error) {
+	where, args := q.forTenant(tenantOf(ctx)).where()
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM invoices %v %v LIMIT ?", colNames, where, q.orderBy()),
+		append(args, q.page.limit+1)...)
 	if err != nil {
-		log.Fatal(err)
+		return invoicePage{}, err
 	}
 	defer rows.Close()
 
@@ -74,19 +187,98 @@ func (model *invoicesModel) getAll(ctx context.Context) ([]invoice, error) {
 	for rows.Next() {
 		i, err := parseRow(rows.Scan)
 		if err != nil {
-			return []invoice{}, err
+			return invoicePage{}, err
 		}
 		invoices = append(invoices, i)
 	}
 	if err := rows.Err(); err != nil {
-		return []invoice{}, err
+		return invoicePage{}, err
 	}
 
-	return invoices, nil
+	page := invoicePage{Invoices: invoices}
+	if len(invoices) > q.page.limit {
+		page.Invoices = invoices[:q.page.limit]
+		page.NextCursor = q.nextCursor(page.Invoices[q.page.limit-1])
+	}
+	return page, nil
 }
 
+// each calls fn for every invoice matching the filters of q in the requested
+// sort order, starting after the cursor of q. Rows are read from the cursor
+// one at a time, so the invoices are never held in memory together. The page
+// limit of q is not applied
+func (model *invoicesModel) each(ctx context.Context, q invoiceQuery, fn func(invoice) error) error {
+	where, args := q.forTenant(tenantOf(ctx)).where()
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM invoices %v %v", colNames, where, q.orderBy()),
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		i, err := parseRow(rows.Scan)
+		if err != nil {
+			return err
+		}
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// getByID returns the invoice unless it has been deleted
 func (model *invoicesModel) getByID(ctx context.Context, ID int) (invoice, error) {
-	row := model.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %v FROM invoices WHERE ID=?", colNames), ID)
+	return queryInvoice(ctx, model.db, ID, false, "")
+}
+
+// getByIDIncludingDeleted returns the invoice even if it has been deleted
+func (model *invoicesModel) getByIDIncludingDeleted(ctx context.Context, ID int) (invoice, error) {
+	return queryInvoice(ctx, model.db, ID, true, "")
+}
+
+func (model *invoicesModel) getByNumber(ctx context.Context, number string, includeDeleted bool) (invoice, error) {
+	var ID int
+	err := model.db.QueryRowContext(ctx, "SELECT ID FROM invoices WHERE TenantID=? AND Number=?", tenantOf(ctx), number).Scan(&ID)
+	if err == sql.ErrNoRows {
+		return invoice{}, NotFoundError(fmt.Sprintf("Invoice with number=%v not found", number))
+	}
+	if err != nil {
+		return invoice{}, err
+	}
+	return queryInvoice(ctx, model.db, ID, includeDeleted, "")
+}
+
+// getByRun returns the invoice created by the run of a schedule at the given
+// time, even if it has been deleted
+func (model *invoicesModel) getByRun(ctx context.Context, scheduleID int, at time.Time) (invoice, error) {
+	var ID int
+	err := model.db.QueryRowContext(ctx,
+		"SELECT ID FROM invoices WHERE TenantID=? AND ScheduleID=? AND ScheduledFor=?",
+		tenantOf(ctx), scheduleID, at).Scan(&ID)
+	if err == sql.ErrNoRows {
+		return invoice{}, NotFoundError(fmt.Sprintf("Invoice of schedule with ID=%d at %v not found", scheduleID, at.Format(time.RFC3339)))
+	}
+	if err != nil {
+		return invoice{}, err
+	}
+	return queryInvoice(ctx, model.db, ID, true, "")
+}
+
+// getInvoiceForUpdate reads the invoice, unless it has been deleted, and locks
+// its row for the remainder of the transaction
+func getInvoiceForUpdate(ctx context.Context, tx *sql.Tx, ID int) (invoice, error) {
+	return queryInvoice(ctx, tx, ID, false, "FOR UPDATE")
+}
+
+func queryInvoice(ctx context.Context, q queryer, ID int, includeDeleted bool, lock string) (invoice, error) {
+	where := "ID=? AND TenantID=? AND DeletedAt IS NULL"
+	if includeDeleted {
+		where = "ID=? AND TenantID=?"
+	}
+	row := q.QueryRowContext(ctx, fmt.Sprintf("SELECT %v FROM invoices WHERE %v %v", colNames, where, lock), ID, tenantOf(ctx))
 	i, err := parseRow(row.Scan)
 
 	switch {
@@ -94,7 +286,274 @@ func (model *invoicesModel) getByID(ctx context.Context, ID int) (invoice, error
 		return invoice{}, NotFoundError(fmt.Sprintf("Invoice with ID=%d not found", ID))
 	case err != nil:
 		return invoice{}, err
-	default:
-		return i, nil
 	}
+
+	lines, err := getLines(ctx, q, ID)
+	if err != nil {
+		return invoice{}, err
+	}
+	if len(lines) > 0 {
+		i.Lines = lines
+		i.TaxSummary = computeTax(lines, i.TaxMode, currencyMinorUnits[i.Currency]).summary
+	}
+	return i, nil
+}
+
+// checkVersion returns PreconditionFailedError unless the invoice has the
+// expected version. An expected version of 0 matches any version
+func checkVersion(i invoice, expectedVersion int) error {
+	if expectedVersion != 0 && i.Version != expectedVersion {
+		return PreconditionFailedError(fmt.Sprintf("Invoice with ID=%d has been modified", i.ID))
+	}
+	return nil
+}
+
+// checkContentChange returns ConflictError if the update changes what is owed
+// on an invoice that is no longer a draft. Payments and credit notes are
+// recorded against the issued amount, so only the description and due date
+// of issued invoices can change
+func checkContentChange(existing invoice, i invoice) error {
+	if existing.Status == statusDraft {
+		return nil
+	}
+	// The amount of invoices with lines is derived from the lines
+	amountChanged := existing.Lines == nil && i.Amount.cmp(existing.Amount) != 0
+	if amountChanged || i.CustomerID != existing.CustomerID || i.Currency != existing.Currency ||
+		i.TaxMode != existing.TaxMode || i.Jurisdiction != existing.Jurisdiction {
+		return ConflictError(fmt.Sprintf("Invoice with ID=%d is %v, only the description and due date can change", existing.ID, existing.Status))
+	}
+	return nil
+}
+
+// update replaces the invoice, provided it still has the expected version
+func (model *invoicesModel) update(ctx context.Context, i invoice, expectedVersion int) (invoice, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return invoice{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, i.ID)
+	if err != nil {
+		return invoice{}, err
+	}
+	if err := checkVersion(existing, expectedVersion); err != nil {
+		return invoice{}, err
+	}
+
+	i = withTaxDefaults(i)
+	if err := checkContentChange(existing, i); err != nil {
+		return invoice{}, err
+	}
+	if err := checkCustomer(ctx, tx, i.CustomerID); err != nil {
+		return invoice{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"UPDATE invoices SET CustomerID=?, DueDate=?, Amount=?, Currency=?, Description=?, TaxMode=?, Jurisdiction=?, Version=Version+1 WHERE ID=?",
+		i.CustomerID,
+		sql.NullTime{Time: i.DueDate, Valid: !i.DueDate.IsZero()},
+		i.Amount,
+		i.Currency,
+		i.Description,
+		i.TaxMode,
+		i.Jurisdiction,
+		i.ID)
+	if isMySQLError(err, errNoReferencedRow) {
+		return invoice{}, ReferenceError(fmt.Sprintf("Customer with ID=%d not found", i.CustomerID))
+	}
+	if err != nil {
+		return invoice{}, err
+	}
+
+	// The amount of invoices with lines is always derived from the lines
+	if existing.Lines != nil {
+		if i.Jurisdiction != existing.Jurisdiction {
+			if err := updateLineRates(ctx, tx, i.ID); err != nil {
+				return invoice{}, err
+			}
+		}
+		if err := updateTotal(ctx, tx, i.ID); err != nil {
+			return invoice{}, err
+		}
+	}
+	if err := audit(ctx, tx, actionUpdate, i.ID, &existing); err != nil {
+		return invoice{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return invoice{}, err
+	}
+
+	return model.getByID(ctx, i.ID)
+}
+
+// delete marks the invoice as deleted, provided it still has the expected
+// version. Invoices are never removed from the database
+func (model *invoicesModel) delete(ctx context.Context, ID int, expectedVersion int) error {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, ID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(existing, expectedVersion); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET DeletedAt=?, Version=Version+1 WHERE ID=?", time.Now().UTC(), ID); err != nil {
+		return err
+	}
+	if err := audit(ctx, tx, actionDelete, ID, &existing); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// restore undoes the deletion of an invoice
+func (model *invoicesModel) restore(ctx context.Context, ID int) (invoice, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return invoice{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := queryInvoice(ctx, tx, ID, true, "FOR UPDATE")
+	if err != nil {
+		return invoice{}, err
+	}
+	if existing.DeletedAt == nil {
+		return invoice{}, ConflictError(fmt.Sprintf("Invoice with ID=%d is not deleted", ID))
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET DeletedAt=NULL, Version=Version+1 WHERE ID=?", ID); err != nil {
+		return invoice{}, err
+	}
+	if err := audit(ctx, tx, actionRestore, ID, &existing); err != nil {
+		return invoice{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return invoice{}, err
+	}
+
+	return model.getByID(ctx, ID)
+}
+
+// transition changes the status of an invoice, provided the change is allowed
+// from its current status
+func (model *invoicesModel) transition(ctx context.Context, ID int, to invoiceStatus) (invoice, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return invoice{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, ID)
+	if err != nil {
+		return invoice{}, err
+	}
+	if !existing.Status.canTransitionTo(to) {
+		return invoice{}, InvalidTransitionError{from: existing.Status, to: to}
+	}
+	// Invoices are paid by recording payments and credit notes, so they can
+	// only be marked as paid by hand once nothing is outstanding
+	if to == statusPaid && existing.Balance.cmp(decimal{}) > 0 {
+		return invoice{}, ConflictError(fmt.Sprintf("Invoice with ID=%d has an outstanding balance of %v %v", ID, existing.Balance, existing.Currency))
+	}
+
+	query, args := "UPDATE invoices SET Status=?, Version=Version+1 WHERE ID=?", []interface{}{to, ID}
+	if to == statusIssued {
+		query, args = "UPDATE invoices SET Status=?, IssuedAt=?, Version=Version+1 WHERE ID=?", []interface{}{to, time.Now().UTC(), ID}
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return invoice{}, err
+	}
+	if err := audit(ctx, tx, actionTransition, ID, &existing); err != nil {
+		return invoice{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return invoice{}, err
+	}
+
+	return model.getByID(ctx, ID)
+}
+
+// overdueLockName names the advisory lock held while marking invoices overdue,
+// so only one replica does so at a time
+const overdueLockName = "invoices.markOverdue"
+
+// markOverdue transitions the issued invoices due before now to overdue, and
+// returns how many were transitioned. When another replica holds the lock
+// nothing is done and locked is false
+func (model *invoicesModel) markOverdue(ctx context.Context, now time.Time) (transitioned int, locked bool, err error) {
+	locked, err = withAdvisoryLock(ctx, model.db, overdueLockName, func(conn *sql.Conn) error {
+		// The job serves every tenant, so each invoice is marked on behalf of
+		// the tenant it belongs to
+		rows, err := conn.QueryContext(ctx,
+			"SELECT ID, TenantID FROM invoices WHERE Status=? AND DueDate < ? AND DeletedAt IS NULL ORDER BY ID",
+			statusIssued, now)
+		if err != nil {
+			return err
+		}
+		IDs := []int{}
+		tenants := []string{}
+		for rows.Next() {
+			var ID int
+			var tenant string
+			if err := rows.Scan(&ID, &tenant); err != nil {
+				rows.Close()
+				return err
+			}
+			IDs = append(IDs, ID)
+			tenants = append(tenants, tenant)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for n, ID := range IDs {
+			ok, err := model.markInvoiceOverdue(withTenant(ctx, tenants[n]), ID, now)
+			if err != nil {
+				return err
+			}
+			if ok {
+				transitioned++
+			}
+		}
+		return nil
+	})
+	return transitioned, locked, err
+}
+
+// markInvoiceOverdue transitions a single invoice to overdue, unless it has
+// been paid, voided or given a later due date since it was selected
+func (model *invoicesModel) markInvoiceOverdue(ctx context.Context, ID int, now time.Time) (bool, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, ID)
+	if _, ok := err.(NotFoundError); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if existing.Status != statusIssued || !existing.DueDate.Before(now) {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET Status=?, Version=Version+1 WHERE ID=?", statusOverdue, ID); err != nil {
+		return false, err
+	}
+	if err := audit(ctx, tx, actionTransition, ID, &existing); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
 }
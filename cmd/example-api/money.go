@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// decimalPlaces is the number of fractional digits kept by decimal, matching
+// the scale of the decimal(12,4) columns in the database
+const decimalPlaces = 4
+
+const decimalScale = 10000
+
+// decimal is an exact fixed-point number. It is serialized as a string in
+// JSON to avoid the rounding artefacts of binary floating point
+type decimal struct {
+	units int64 // value * decimalScale
+}
+
+var errDecimalSyntax = errors.New("Invalid decimal number")
+
+var errDecimalOverflow = errors.New("Decimal number out of range")
+
+func newDecimal(i int64) decimal {
+	return decimal{units: i * decimalScale}
+}
+
+// parseDecimal parses numbers like "1024.12" or "-3". Numbers with more than
+// four fractional digits are rejected rather than rounded
+func parseDecimal(s string) (decimal, error) {
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	parts := strings.SplitN(s, ".", 2)
+	if parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return decimal{}, errDecimalSyntax
+	}
+	fraction := ""
+	if len(parts) == 2 {
+		fraction = parts[1]
+	}
+	if len(fraction) > decimalPlaces {
+		return decimal{}, fmt.Errorf("Decimal number %q has more than %v decimal places", s, decimalPlaces)
+	}
+
+	digits := parts[0] + fraction + strings.Repeat("0", decimalPlaces-len(fraction))
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return decimal{}, errDecimalSyntax
+		}
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return decimal{}, errDecimalSyntax
+	}
+
+	if negative {
+		units = -units
+	}
+	return decimal{units: units}, nil
+}
+
+func (d decimal) String() string {
+	units := d.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	s := fmt.Sprintf("%v%d.%04d", sign, units/decimalScale, units%decimalScale)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+func (d decimal) add(o decimal) decimal {
+	return decimal{units: d.units + o.units}
+}
+
+func (d decimal) sub(o decimal) decimal {
+	return decimal{units: d.units - o.units}
+}
+
+func (d decimal) neg() decimal {
+	return decimal{units: -d.units}
+}
+
+func (d decimal) cmp(o decimal) int {
+	switch {
+	case d.units < o.units:
+		return -1
+	case d.units > o.units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (d decimal) isZero() bool {
+	return d.units == 0
+}
+
+func (d decimal) isNegative() bool {
+	return d.units < 0
+}
+
+// mul returns d*o rounded half away from zero to four decimal places. It
+// panics if the product is out of range, which validation rules out by
+// bounding amounts and line totals by maxAmount
+func (d decimal) mul(o decimal) decimal {
+	product, ok := d.checkedMul(o)
+	if !ok {
+		panic(errDecimalOverflow)
+	}
+	return product
+}
+
+// checkedMul returns d*o like mul, or false if the product is out of range
+func (d decimal) checkedMul(o decimal) (decimal, bool) {
+	product := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(o.units))
+	q := bigDivRound(product, big.NewInt(decimalScale))
+	if !q.IsInt64() {
+		return decimal{}, false
+	}
+	return decimal{units: q.Int64()}, true
+}
+
+// div returns d/o rounded half away from zero to four decimal places
+func (d decimal) div(o decimal) decimal {
+	numerator := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(decimalScale))
+	return decimal{units: divRound(numerator, big.NewInt(o.units))}
+}
+
+// round rounds half away from zero to the given number of decimal places
+func (d decimal) round(places int) decimal {
+	if places >= decimalPlaces {
+		return d
+	}
+	factor := int64(math.Pow10(decimalPlaces - places))
+	return decimal{units: divRound(big.NewInt(d.units), big.NewInt(factor)) * factor}
+}
+
+// fixed formats d rounded to the given number of decimal places, padding the
+// fraction with zeros, e.g. "10.50"
+func (d decimal) fixed(places int) string {
+	s := d.round(places).String()
+	if places == 0 {
+		return s
+	}
+	fraction := 0
+	if n := strings.IndexByte(s, '.'); n >= 0 {
+		fraction = len(s) - n - 1
+	} else {
+		s += "."
+	}
+	return s + strings.Repeat("0", places-fraction)
+}
+
+// places returns the number of significant fractional digits
+func (d decimal) places() int {
+	units := d.units
+	places := decimalPlaces
+	for places > 0 && units%10 == 0 {
+		units /= 10
+		places--
+	}
+	return places
+}
+
+func divRound(n *big.Int, d *big.Int) int64 {
+	q := bigDivRound(n, d)
+	if !q.IsInt64() {
+		panic(errDecimalOverflow)
+	}
+	return q.Int64()
+}
+
+func bigDivRound(n *big.Int, d *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(n, d, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(new(big.Int).Abs(d)) >= 0 {
+		if (n.Sign() < 0) != (d.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q
+}
+
+// MarshalJSON implements json.Marshaler
+func (d decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting both strings and numbers
+func (d *decimal) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	}
+	parsed, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Scan implements sql.Scanner
+func (d *decimal) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return d.scanString(string(v))
+	case string:
+		return d.scanString(v)
+	case int64:
+		*d = newDecimal(v)
+		return nil
+	default:
+		return fmt.Errorf("Cannot scan %T into decimal", src)
+	}
+}
+
+func (d *decimal) scanString(s string) error {
+	parsed, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Value implements driver.Valuer
+func (d decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+// currencyMinorUnits maps ISO 4217 currency codes to the number of decimal
+// places used by the currency
+var currencyMinorUnits = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"BRL": 2,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"CZK": 2,
+	"DKK": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"HUF": 2,
+	"INR": 2,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"MXN": 2,
+	"NOK": 2,
+	"NZD": 2,
+	"OMR": 3,
+	"PLN": 2,
+	"SEK": 2,
+	"SGD": 2,
+	"TND": 3,
+	"USD": 2,
+	"ZAR": 2,
+}
+
+// money is an exact amount in an ISO 4217 currency
+type money struct {
+	amount   decimal
+	currency string
+}
+
+// validate checks that the currency is known and that the amount does not
+// use more decimal places than the currency allows. Errors are reported
+// against the given amount and currency field names
+func (m money) validate(amountField string, currencyField string) ValidationError {
+	errs := ValidationError{}
+
+	minorUnits, ok := currencyMinorUnits[m.currency]
+	switch {
+	case m.currency == "":
+		errs = append(errs, fieldError{currencyField, "required", "Currency is required"})
+	case !ok:
+		errs = append(errs, fieldError{currencyField, "invalid", fmt.Sprintf("Unknown ISO 4217 currency %q", m.currency)})
+	case m.amount.places() > minorUnits:
+		errs = append(errs, fieldError{amountField, "precision", fmt.Sprintf("Amount must not have more than %v decimal places in %v", minorUnits, m.currency)})
+	}
+	return errs
+}
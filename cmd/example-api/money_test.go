@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+	for _, x := range []struct {
+		input    string
+		expected string
+		valid    bool
+	}{
+		{"1024.12", "1024.12", true},
+		{"1024.1200", "1024.12", true},
+		{"-0.5", "-0.5", true},
+		{"300", "300", true},
+		{"0.0001", "0.0001", true},
+		{"0.00001", "", false},
+		{"1.", "", false},
+		{".5", "", false},
+		{"1e3", "", false},
+		{"abc", "", false},
+	} {
+		t.Run(x.input, func(t *testing.T) {
+			d, err := parseDecimal(x.input)
+			if (err == nil) != x.valid {
+				t.Errorf("Expected valid=%v, got error %v", x.valid, err)
+			}
+			if x.valid && d.String() != x.expected {
+				t.Errorf("Expected %v, got %v", x.expected, d)
+			}
+		})
+	}
+}
+
+func TestDecimal_Arithmetic(t *testing.T) {
+	t.Run("Adds without rounding artefacts", func(t *testing.T) {
+		sum := mustDecimal("1024.1").add(mustDecimal("0.02"))
+		if sum.String() != "1024.12" {
+			t.Errorf("Expected 1024.12, got %v", sum)
+		}
+	})
+
+	t.Run("Multiplies rounding half away from zero", func(t *testing.T) {
+		product := mustDecimal("0.0005").mul(mustDecimal("0.5"))
+		if product.String() != "0.0003" {
+			t.Errorf("Expected 0.0003, got %v", product)
+		}
+		product = mustDecimal("-0.0005").mul(mustDecimal("0.5"))
+		if product.String() != "-0.0003" {
+			t.Errorf("Expected -0.0003, got %v", product)
+		}
+	})
+
+	t.Run("Detects products out of range", func(t *testing.T) {
+		if product, ok := maxAmount.checkedMul(maxAmount); ok {
+			t.Errorf("Expected %v * %v to overflow, got %v", maxAmount, maxAmount, product)
+		}
+		line := invoiceLine{Description: "Consulting", Quantity: maxAmount, UnitPrice: maxAmount}
+		if errs := line.validate(""); len(errs) == 0 {
+			t.Errorf("Expected line total out of range to be rejected")
+		}
+	})
+
+	t.Run("Rounds to currency precision", func(t *testing.T) {
+		rounded := mustDecimal("10.125").round(2)
+		if rounded.String() != "10.13" {
+			t.Errorf("Expected 10.13, got %v", rounded)
+		}
+	})
+
+	t.Run("Formats with fixed decimal places", func(t *testing.T) {
+		for _, x := range []struct {
+			input    string
+			places   int
+			expected string
+		}{
+			{"10.5", 2, "10.50"},
+			{"10", 2, "10.00"},
+			{"10.125", 2, "10.13"},
+			{"-0.5", 3, "-0.500"},
+			{"10.5", 0, "11"},
+		} {
+			if s := mustDecimal(x.input).fixed(x.places); s != x.expected {
+				t.Errorf("Expected %v, got %v", x.expected, s)
+			}
+		}
+	})
+}
+
+func TestDecimal_JSON(t *testing.T) {
+	var v struct {
+		Amount decimal `json:"amount"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"amount": 1024.12}`), &v); err != nil {
+		t.Errorf(err.Error())
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Errorf(err.Error())
+	}
+	if string(b) != `{"amount":"1024.12"}` {
+		t.Errorf("Expected amount serialized as a string, got %v", string(b))
+	}
+}
+
+func TestMoney_Validate(t *testing.T) {
+	for _, x := range []struct {
+		m     money
+		valid bool
+	}{
+		{money{mustDecimal("10.50"), "NOK"}, true},
+		{money{mustDecimal("10.505"), "NOK"}, false},
+		{money{mustDecimal("1000"), "JPY"}, true},
+		{money{mustDecimal("1000.5"), "JPY"}, false},
+		{money{mustDecimal("10"), "XXX"}, false},
+	} {
+		if errs := x.m.validate("amount", "currency"); (len(errs) == 0) != x.valid {
+			t.Errorf("%v %v: expected valid=%v, got errors %v", x.m.amount, x.m.currency, x.valid, errs)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultNumberSeries is the series invoices are numbered from unless another
+// series is requested
+const defaultNumberSeries = "default"
+
+// numberSeries describes how invoice numbers of a series are formatted. A
+// yearly series restarts from 1 every calendar year and has the year in its
+// numbers
+type numberSeries struct {
+	prefix  string
+	padding int
+	yearly  bool
+}
+
+// sequence returns the name of the number sequence the series allocates from
+// for the tenant in the given year. Every tenant numbers its invoices
+// separately
+func (s numberSeries) sequence(tenant string, name string, year int) string {
+	if s.yearly {
+		return fmt.Sprintf("invoice:%v:%v:%d", tenant, name, year)
+	}
+	return fmt.Sprintf("invoice:%v:%v", tenant, name)
+}
+
+func (s numberSeries) format(n int, year int) string {
+	if s.yearly {
+		return fmt.Sprintf("%v%d-%0*d", s.prefix, year, s.padding, n)
+	}
+	return fmt.Sprintf("%v%0*d", s.prefix, s.padding, n)
+}
+
+// parseNumberSeries parses a comma separated list of series on the form
+// name:prefix:padding[:yearly], such as "default:INV-:6:yearly,export:EX-:4"
+func parseNumberSeries(value string) (map[string]numberSeries, error) {
+	series := map[string]numberSeries{}
+	for _, def := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(def), ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || len(parts[0]) > 30 {
+			return nil, fmt.Errorf("Invalid number series %q, must be name:prefix:padding[:yearly]", def)
+		}
+		padding, err := strconv.Atoi(parts[2])
+		if err != nil || padding < 1 || padding > 20 {
+			return nil, fmt.Errorf("Invalid padding of number series %q, must be between 1 and 20", parts[0])
+		}
+		s := numberSeries{prefix: parts[1], padding: padding}
+		if len(parts) == 4 {
+			if parts[3] != "yearly" {
+				return nil, fmt.Errorf("Invalid reset of number series %q, must be yearly", parts[0])
+			}
+			s.yearly = true
+		}
+		if _, ok := series[parts[0]]; ok {
+			return nil, fmt.Errorf("Number series %q is defined more than once", parts[0])
+		}
+		series[parts[0]] = s
+	}
+	if _, ok := series[defaultNumberSeries]; !ok {
+		return nil, fmt.Errorf("Number series %q must be defined", defaultNumberSeries)
+	}
+	return series, nil
+}
+
+// nextNumber takes the next value of the named sequence, starting a new
+// sequence at 1. The sequence row is locked until tx ends, so numbers are
+// handed out without gaps
+func nextNumber(ctx context.Context, tx *sql.Tx, sequence string) (int, error) {
+	if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO number_sequences (Name, NextValue) VALUES (?, 1)", sequence); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := tx.QueryRowContext(ctx, "SELECT NextValue FROM number_sequences WHERE Name=? FOR UPDATE", sequence).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE number_sequences SET NextValue=NextValue+1 WHERE Name=?", sequence); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
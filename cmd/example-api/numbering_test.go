@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseNumberSeries(t *testing.T) {
+	series, err := parseNumberSeries("default:INV-:6:yearly, export:EX-:4")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(series) != 2 {
+		t.Fatalf("Should parse %v series. Parsed %v", 2, len(series))
+	}
+
+	for _, x := range []struct {
+		series   string
+		n        int
+		expected string
+	}{
+		{"default", 1, "INV-2019-000001"},
+		{"default", 1234567, "INV-2019-1234567"},
+		{"export", 42, "EX-0042"},
+	} {
+		if number := series[x.series].format(x.n, 2019); number != x.expected {
+			t.Errorf("Should format %v of series %v as %v. Formatted as %v", x.n, x.series, x.expected, number)
+		}
+	}
+
+	if series["default"].sequence("acme", "default", 2019) == series["default"].sequence("acme", "default", 2020) {
+		t.Errorf("Yearly series should use a sequence per year")
+	}
+	if series["export"].sequence("acme", "export", 2019) != series["export"].sequence("acme", "export", 2020) {
+		t.Errorf("Series without reset should use the same sequence every year")
+	}
+	if series["export"].sequence("acme", "export", 2019) == series["export"].sequence("globex", "export", 2019) {
+		t.Errorf("Tenants should use separate sequences")
+	}
+
+	for _, value := range []string{
+		"",
+		"export:EX-:4",
+		"default:INV-",
+		"default:INV-:x",
+		"default:INV-:0",
+		"default:INV-:6:monthly",
+		"default:INV-:6,default:X-:6",
+	} {
+		if _, err := parseNumberSeries(value); err == nil {
+			t.Errorf("Should reject %q", value)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// overdueJob periodically marks invoices past their due date as overdue
+func overdueJob(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		ctx := withActor(context.Background(), systemActor)
+		transitioned, locked, err := model.markOverdue(ctx, time.Now().UTC())
+		switch {
+		case err != nil:
+			log.Println(fmt.Sprintf("ERROR: Overdue job: %q", err))
+		case !locked:
+			log.Println("Overdue job: skipped, running on another replica")
+		default:
+			log.Println(fmt.Sprintf("Overdue job: transitioned=%v", transitioned))
+		}
+	}
+}
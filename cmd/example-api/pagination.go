@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+// pageRequest represents the requested window of a keyset paginated list.
+// after holds the sort values of the last item on the previous page, or nil
+// for the first page
+type pageRequest struct {
+	after []interface{}
+	limit int
+}
+
+// cursor is the decoded form of the opaque cursor handed out to clients. It
+// records the sort order it was created for along with the sort values of
+// the last item on the page
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+func encodeCursor(c cursor) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, errors.New("Invalid cursor")
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return cursor{}, errors.New("Invalid cursor")
+	}
+	return c, nil
+}
+
+// encodeIDCursor returns the cursor of a list ordered by ID only
+func encodeIDCursor(ID int) string {
+	return encodeCursor(cursor{Sort: "id", Values: []string{strconv.Itoa(ID)}})
+}
+
+// parseIDCursor reads the cursor query parameter of a list ordered by ID only
+func parseIDCursor(query url.Values) (int, error) {
+	v := query.Get("cursor")
+	if v == "" {
+		return 0, nil
+	}
+
+	c, err := decodeCursor(v)
+	if err != nil {
+		return 0, err
+	}
+	if c.Sort != "id" || len(c.Values) != 1 {
+		return 0, errors.New("Invalid cursor")
+	}
+	return strconv.Atoi(c.Values[0])
+}
+
+// parseLimit reads the limit query parameter, applying the configured
+// default and maximum page sizes
+func parseLimit(query url.Values) (int, error) {
+	limit := config.pagination.defaultLimit
+
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return 0, errors.New("Invalid limit")
+		}
+		limit = l
+	}
+	if limit > config.pagination.maxLimit {
+		limit = config.pagination.maxLimit
+	}
+	return limit, nil
+}
+
+// nextPageURL returns the request URI of the page following the current one
+func nextPageURL(u *url.URL, nextCursor string) string {
+	next := *u
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	next.RawQuery = query.Encode()
+	return next.RequestURI()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396) to the given invoice
+// and returns the patched copy
+func applyMergePatch(i invoice, patch interface{}) (invoice, error) {
+	original, err := json.Marshal(i)
+	if err != nil {
+		return invoice{}, err
+	}
+
+	var target interface{}
+	if err := json.Unmarshal(original, &target); err != nil {
+		return invoice{}, err
+	}
+
+	patched, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return invoice{}, err
+	}
+
+	var result invoice
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return invoice{}, err
+	}
+	return result, nil
+}
+
+// mergePatch merges patch into target following the rules of RFC 7396:
+// objects are merged recursively, null removes a member, and any other
+// value replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+		} else {
+			targetObj[k] = mergePatch(targetObj[k], v)
+		}
+	}
+	return targetObj
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func getInvoicePayments(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	payments, err := model.getPayments(ctx, invoiceID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(payments); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func createInvoicePayment(w http.ResponseWriter, r *http.Request) {
+	invoiceID, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	allowOverpayment := false
+	if v := r.URL.Query().Get("allowOverpayment"); v != "" {
+		var err error
+		if allowOverpayment, err = strconv.ParseBool(v); err != nil {
+			writeInvalidFields(w, r, InvalidFieldsError{"allowOverpayment"})
+			return
+		}
+	}
+
+	var p payment
+	if !readJSON(w, r, &p) {
+		return
+	}
+	if errs := p.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	if p.ReceivedAt.IsZero() {
+		p.ReceivedAt = time.Now().UTC()
+	}
+
+	ctx := r.Context()
+	result, err := model.createPayment(ctx, invoiceID, p, allowOverpayment)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
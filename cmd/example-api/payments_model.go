@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const paymentColNames = "ID, InvoiceID, Amount, ReceivedAt, Reference"
+
+func parsePaymentRow(scanFn func(...interface{}) error) (payment, error) {
+	var p payment
+	var reference sql.NullString
+	if err := scanFn(&p.ID, &p.InvoiceID, &p.Amount, &p.ReceivedAt, &reference); err != nil {
+		return payment{}, err
+	}
+	p.Reference = reference.String
+	return p, nil
+}
+
+func (model *invoicesModel) getPayments(ctx context.Context, invoiceID int) ([]payment, error) {
+	if _, err := model.getByID(ctx, invoiceID); err != nil {
+		return nil, err
+	}
+
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM payments WHERE InvoiceID=? ORDER BY ReceivedAt, ID", paymentColNames),
+		invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []payment{}
+	for rows.Next() {
+		p, err := parsePaymentRow(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// createPayment records money received against an issued or overdue invoice,
+// and marks the invoice as paid once nothing is outstanding. A payment larger
+// than the outstanding balance is rejected unless allowOverpayment is set
+func (model *invoicesModel) createPayment(ctx context.Context, invoiceID int, p payment, allowOverpayment bool) (payment, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return payment{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoiceForUpdate(ctx, tx, invoiceID)
+	if err != nil {
+		return payment{}, err
+	}
+	if existing.Status != statusIssued && existing.Status != statusOverdue {
+		return payment{}, ConflictError(fmt.Sprintf("Invoice with ID=%d is %v and cannot receive payments", invoiceID, existing.Status))
+	}
+	if errs := (money{p.Amount, existing.Currency}).validate("amount", "currency"); len(errs) > 0 {
+		return payment{}, errs
+	}
+	if p.Amount.cmp(existing.Balance) > 0 && !allowOverpayment {
+		return payment{}, ValidationError{{"amount", "overpayment", fmt.Sprintf("Payment exceeds the outstanding balance of %v %v", existing.Balance, existing.Currency)}}
+	}
+
+	var reference sql.NullString
+	if p.Reference != "" {
+		reference = sql.NullString{String: p.Reference, Valid: true}
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO payments (InvoiceID, Amount, ReceivedAt, Reference) VALUES (?, ?, ?, ?)",
+		invoiceID,
+		p.Amount,
+		p.ReceivedAt,
+		reference)
+	if err != nil {
+		return payment{}, err
+	}
+	ID, err := result.LastInsertId()
+	if err != nil {
+		return payment{}, err
+	}
+
+	status := existing.Status
+	if remaining := existing.Balance.sub(p.Amount); remaining.isZero() || remaining.isNegative() {
+		status = statusPaid
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE invoices SET Status=?, Version=Version+1 WHERE ID=?", status, invoiceID); err != nil {
+		return payment{}, err
+	}
+	if err := audit(ctx, tx, actionPayment, invoiceID, &existing); err != nil {
+		return payment{}, err
+	}
+
+	created, err := parsePaymentRow(tx.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %v FROM payments WHERE ID=?", paymentColNames), ID).Scan)
+	if err != nil {
+		return payment{}, err
+	}
+	return created, tx.Commit()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problem is an RFC 7807 problem details object describing an error response
+type problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	CorrelationID string       `json:"correlationID,omitempty"`
+	InvalidFields []string     `json:"invalidFields,omitempty"`
+	Errors        []fieldError `json:"errors,omitempty"`
+}
+
+func newProblem(status int, detail string) problem {
+	return problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// writeProblem responds with the problem encoded as application/problem+json
+func writeProblem(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Instance = r.URL.RequestURI()
+	p.CorrelationID = logger.getID(r)
+
+	w.Header().Set("Content-Type", "application/problem+json; charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+// writeError responds with a problem of the given status and detail
+func writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblem(w, r, newProblem(status, detail))
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// invoiceField describes an invoice attribute that can be used for
+// filtering and sorting the invoice list. Nullable fields format NULL as ""
+type invoiceField struct {
+	column   string
+	nullable bool
+	parse    func(string) (interface{}, error)
+	format   func(invoice) string
+}
+
+var invoiceFields = map[string]invoiceField{
+	"id": {
+		column: "ID",
+		parse:  parseInt,
+		format: func(i invoice) string { return strconv.Itoa(i.ID) },
+	},
+	"customerID": {
+		column: "CustomerID",
+		parse:  parseInt,
+		format: func(i invoice) string { return strconv.Itoa(i.CustomerID) },
+	},
+	"dueDate": {
+		column:   "DueDate",
+		nullable: true,
+		parse:    parseTime,
+		format: func(i invoice) string {
+			if i.DueDate.IsZero() {
+				return ""
+			}
+			return i.DueDate.Format(time.RFC3339Nano)
+		},
+	},
+	"amount": {
+		column: "Amount",
+		parse:  parseAmount,
+		format: func(i invoice) string { return i.Amount.String() },
+	},
+	"status": {
+		column: "Status",
+		parse:  parseStatus,
+		format: func(i invoice) string { return string(i.Status) },
+	},
+}
+
+// invoiceFilters maps the supported filter query parameters to the field
+// and SQL comparison they translate into
+var invoiceFilters = map[string]struct {
+	field    string
+	operator string
+}{
+	"customerID":  {"customerID", "="},
+	"dueDateFrom": {"dueDate", ">="},
+	"dueDateTo":   {"dueDate", "<="},
+	"amountFrom":  {"amount", ">="},
+	"amountTo":    {"amount", "<="},
+	"status":      {"status", "="},
+}
+
+// condition is a parameterized SQL boolean expression
+type condition struct {
+	clause string
+	args   []interface{}
+}
+
+type sortField struct {
+	name string
+	desc bool
+}
+
+// invoiceQuery represents the filters, ordering and page requested for the
+// invoice list
+type invoiceQuery struct {
+	filters        []condition
+	sort           []sortField
+	page           pageRequest
+	includeDeleted bool
+}
+
+// parseInvoiceQuery translates the query string of GET /invoices into an
+// invoiceQuery. All invalid parameters are reported in a single
+// InvalidFieldsError
+func parseInvoiceQuery(query url.Values) (invoiceQuery, error) {
+	var q invoiceQuery
+	invalid := InvalidFieldsError{}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := query.Get(key)
+		switch key {
+		case "limit", "cursor":
+		case "includeDeleted":
+			includeDeleted, err := strconv.ParseBool(value)
+			if err != nil {
+				invalid = append(invalid, key)
+			}
+			q.includeDeleted = includeDeleted
+		case "sort":
+			fields, invalidFields := parseSort(value)
+			invalid = append(invalid, invalidFields...)
+			q.sort = fields
+		case "description":
+			q.filters = append(q.filters, condition{"Description LIKE ?", []interface{}{"%" + escapeLike(value) + "%"}})
+		default:
+			filter, ok := invoiceFilters[key]
+			if !ok {
+				invalid = append(invalid, key)
+				continue
+			}
+			field := invoiceFields[filter.field]
+			v, err := field.parse(value)
+			if err != nil {
+				invalid = append(invalid, key)
+				continue
+			}
+			operator := filter.operator
+			// A date as upper bound includes the whole day
+			if t, ok := v.(time.Time); ok && operator == "<=" && isDate(value) {
+				operator, v = "<", t.AddDate(0, 0, 1)
+			}
+			q.filters = append(q.filters, condition{fmt.Sprintf("%v %v ?", field.column, operator), []interface{}{v}})
+		}
+	}
+
+	if !hasSortField(q.sort, "id") {
+		q.sort = append(q.sort, sortField{name: "id"})
+	}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		invalid = append(invalid, "limit")
+	}
+	q.page.limit = limit
+
+	if v := query.Get("cursor"); v != "" {
+		after, err := parseCursorValues(v, q.sort)
+		if err != nil {
+			invalid = append(invalid, "cursor")
+		}
+		q.page.after = after
+	}
+
+	if len(invalid) > 0 {
+		return invoiceQuery{}, invalid
+	}
+	return q, nil
+}
+
+func parseSort(value string) ([]sortField, []string) {
+	fields := []sortField{}
+	invalid := []string{}
+
+	for _, name := range strings.Split(value, ",") {
+		f := sortField{name: strings.TrimSpace(name)}
+		if strings.HasPrefix(f.name, "-") {
+			f.name = f.name[1:]
+			f.desc = true
+		}
+		if _, ok := invoiceFields[f.name]; !ok || hasSortField(fields, f.name) {
+			invalid = append(invalid, name)
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields, invalid
+}
+
+func hasSortField(fields []sortField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKey returns the canonical string form of a sort order, e.g. "dueDate,-id"
+func sortKey(fields []sortField) string {
+	names := make([]string, len(fields))
+	for n, f := range fields {
+		if f.desc {
+			names[n] = "-" + f.name
+		} else {
+			names[n] = f.name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func parseCursorValues(s string, fields []sortField) ([]interface{}, error) {
+	c, err := decodeCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	if c.Sort != sortKey(fields) || len(c.Values) != len(fields) {
+		return nil, fmt.Errorf("Cursor does not match sort=%v", sortKey(fields))
+	}
+
+	values := make([]interface{}, len(fields))
+	for n, f := range fields {
+		field := invoiceFields[f.name]
+		if field.nullable && c.Values[n] == "" {
+			continue
+		}
+		v, err := field.parse(c.Values[n])
+		if err != nil {
+			return nil, err
+		}
+		values[n] = v
+	}
+	return values, nil
+}
+
+// nextCursor returns the cursor pointing past the given invoice in the
+// query's sort order
+func (q invoiceQuery) nextCursor(last invoice) string {
+	values := make([]string, len(q.sort))
+	for n, f := range q.sort {
+		values[n] = invoiceFields[f.name].format(last)
+	}
+	return encodeCursor(cursor{Sort: sortKey(q.sort), Values: values})
+}
+
+// forTenant returns the query restricted to the invoices of the tenant
+func (q invoiceQuery) forTenant(tenant string) invoiceQuery {
+	q.filters = append(append([]condition{}, q.filters...), condition{"TenantID = ?", []interface{}{tenant}})
+	return q
+}
+
+// where returns the SQL WHERE clause combining the filters with the keyset
+// condition selecting rows after the cursor. Deleted invoices are excluded
+// unless includeDeleted is set
+func (q invoiceQuery) where() (string, []interface{}) {
+	conditions := append([]condition{}, q.filters...)
+	if !q.includeDeleted {
+		conditions = append(conditions, condition{"DeletedAt IS NULL", nil})
+	}
+	if q.page.after != nil {
+		conditions = append(conditions, q.keyset())
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	clauses := make([]string, len(conditions))
+	args := []interface{}{}
+	for n, c := range conditions {
+		clauses[n] = "(" + c.clause + ")"
+		args = append(args, c.args...)
+	}
+	return "WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// keyset expands the row comparison against the cursor values, e.g. for
+// sort=a,-b: (a > ?) OR (a = ? AND b < ?)
+func (q invoiceQuery) keyset() condition {
+	alternatives := []string{}
+	args := []interface{}{}
+
+	for n, f := range q.sort {
+		terms := []condition{}
+		for m := 0; m < n; m++ {
+			terms = append(terms, invoiceFields[q.sort[m].name].equal(q.page.after[m]))
+		}
+		terms = append(terms, invoiceFields[f.name].after(q.page.after[n], f.desc))
+
+		clauses := make([]string, len(terms))
+		for m, t := range terms {
+			clauses[m] = t.clause
+			args = append(args, t.args...)
+		}
+		alternatives = append(alternatives, "("+strings.Join(clauses, " AND ")+")")
+	}
+	return condition{strings.Join(alternatives, " OR "), args}
+}
+
+// equal returns the condition selecting rows where the field equals the
+// cursor value v, which is nil for NULL
+func (f invoiceField) equal(v interface{}) condition {
+	if v == nil {
+		return condition{f.column + " IS NULL", nil}
+	}
+	return condition{f.column + " = ?", []interface{}{v}}
+}
+
+// after returns the condition selecting rows sorted after the cursor value v,
+// which is nil for NULL. MySQL sorts NULL before any value
+func (f invoiceField) after(v interface{}, desc bool) condition {
+	switch {
+	case !desc && v == nil:
+		return condition{f.column + " IS NOT NULL", nil}
+	case !desc:
+		return condition{f.column + " > ?", []interface{}{v}}
+	case v == nil:
+		return condition{"FALSE", nil}
+	case f.nullable:
+		return condition{fmt.Sprintf("(%v < ? OR %v IS NULL)", f.column, f.column), []interface{}{v}}
+	default:
+		return condition{f.column + " < ?", []interface{}{v}}
+	}
+}
+
+// orderBy returns the SQL ORDER BY clause of the query's sort order
+func (q invoiceQuery) orderBy() string {
+	terms := make([]string, len(q.sort))
+	for n, f := range q.sort {
+		terms[n] = invoiceFields[f.name].column
+		if f.desc {
+			terms[n] += " DESC"
+		}
+	}
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+func parseInt(s string) (interface{}, error) {
+	return strconv.Atoi(s)
+}
+
+func parseAmount(s string) (interface{}, error) {
+	return parseDecimal(s)
+}
+
+func parseStatus(s string) (interface{}, error) {
+	if status := invoiceStatus(s); status.valid() {
+		return status, nil
+	}
+	return nil, fmt.Errorf("Invalid status=%q", s)
+}
+
+// parseTime accepts RFC 3339 timestamps as well as plain dates
+func parseTime(s string) (interface{}, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// isDate reports whether s is a plain date rather than a timestamp
+func isDate(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+// escapeLike escapes the wildcard characters of a MySQL LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
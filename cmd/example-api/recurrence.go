@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// intervalUnit is the unit of the interval between the runs of a schedule
+type intervalUnit string
+
+const (
+	unitDay   intervalUnit = "day"
+	unitWeek  intervalUnit = "week"
+	unitMonth intervalUnit = "month"
+	unitYear  intervalUnit = "year"
+)
+
+func (u intervalUnit) valid() bool {
+	switch u {
+	case unitDay, unitWeek, unitMonth, unitYear:
+		return true
+	}
+	return false
+}
+
+// addInterval returns the time n units after anchor. Months and years are
+// counted from the anchor, so runs from the end of a month fall on the last
+// day of shorter months without drifting to earlier days afterwards
+func addInterval(anchor time.Time, unit intervalUnit, n int) time.Time {
+	switch unit {
+	case unitDay:
+		return anchor.AddDate(0, 0, n)
+	case unitWeek:
+		return anchor.AddDate(0, 0, 7*n)
+	case unitYear:
+		n *= 12
+	}
+
+	y, m, d := anchor.Date()
+	first := time.Date(y, m+time.Month(n), 1, anchor.Hour(), anchor.Minute(), anchor.Second(), anchor.Nanosecond(), anchor.Location())
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+	return first.AddDate(0, 0, d-1)
+}
+
+// cronMacros are the shorthands accepted in place of cron expressions
+var cronMacros = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+}
+
+var cronFields = []struct {
+	name string
+	min  int
+	max  int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronSearchYears bounds how far ahead matches of cron expressions are looked
+// for, so expressions that never match, such as "0 0 30 2 *", are detected
+const cronSearchYears = 10
+
+// cronSpec is a parsed cron expression. Each field is a set of bits, one for
+// every value the field matches
+type cronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// Like in cron, a day matches when either the day of month or the day of
+	// week matches, unless one of them is unrestricted
+	domAny bool
+	dowAny bool
+}
+
+// parseCron parses the five field cron expression "minute hour day-of-month
+// month day-of-week", where fields are lists of values, ranges and steps such
+// as "*", "1,15", "1-5" and "*/10". Expressions are evaluated in UTC
+func parseCron(expr string) (cronSpec, error) {
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSpec{}, fmt.Errorf("Cron expression must have %d fields, got %d", len(cronFields), len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for n, f := range fields {
+		b, err := parseCronField(f, cronFields[n].min, cronFields[n].max)
+		if err != nil {
+			return cronSpec{}, fmt.Errorf("Invalid %v %q: %v", cronFields[n].name, f, err)
+		}
+		bits[n] = b
+	}
+	// Sunday is both 0 and 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return cronSpec{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s < 1 {
+				return 0, errors.New("step must be a positive integer")
+			}
+			rng, step = item[:i], s
+		}
+
+		lo, hi := min, max
+		var err error
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err == nil {
+				hi, err = strconv.Atoi(bounds[1])
+			}
+		default:
+			// A single value with a step, such as "5/15", runs to the maximum
+			if lo, err = strconv.Atoi(rng); err == nil && step == 1 {
+				hi = lo
+			}
+		}
+		if err != nil {
+			return 0, errors.New("values must be integers")
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("values must be within %d-%d", min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after the given time that matches the
+// expression, or false if there is none within cronSearchYears
+func (c cronSpec) next(after time.Time) (time.Time, bool) {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextRun returns the first run of the schedule after the given time, or false
+// if the schedule never runs again. Schedules never run before their anchor
+func (s schedule) nextRun(after time.Time) (time.Time, bool) {
+	if after.Before(s.Anchor) {
+		after = s.Anchor.Add(-time.Nanosecond)
+	}
+
+	if s.Cron != "" {
+		spec, err := parseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return spec.next(after)
+	}
+	if s.Every < 1 || !s.Unit.valid() {
+		return time.Time{}, false
+	}
+
+	// Estimate the number of intervals since the anchor, then correct for the
+	// varying lengths of months
+	var n int
+	switch s.Unit {
+	case unitDay:
+		n = int(after.Sub(s.Anchor).Hours() / 24)
+	case unitWeek:
+		n = int(after.Sub(s.Anchor).Hours() / (24 * 7))
+	case unitMonth:
+		n = (after.Year()-s.Anchor.Year())*12 + int(after.Month()-s.Anchor.Month())
+	case unitYear:
+		n = after.Year() - s.Anchor.Year()
+	}
+	n /= s.Every
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && addInterval(s.Anchor, s.Unit, n*s.Every).After(after) {
+		n--
+	}
+	for !addInterval(s.Anchor, s.Unit, n*s.Every).After(after) {
+		n++
+	}
+	return addInterval(s.Anchor, s.Unit, n*s.Every), true
+}
+
+// firstRun returns the first run of the schedule at or after the given time
+func (s schedule) firstRun(at time.Time) (time.Time, bool) {
+	return s.nextRun(at.Add(-time.Nanosecond))
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"0 6 1 * *",
+		"*/15 8-17 * * 1-5",
+		"0 0 1,15 * *",
+		"30 4 * * 7",
+		"5/20 * * * *",
+		"@monthly",
+	} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("Should parse %q. Failed with %v", expr, err)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"0 6 1 *",
+		"0 6 1 * * *",
+		"60 * * * *",
+		"0 24 * * *",
+		"0 0 0 * *",
+		"0 0 * 13 *",
+		"0 0 * * 8",
+		"0 0 5-1 * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@hourly",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Should reject %q", expr)
+		}
+	}
+}
+
+func TestCronSpec_Next(t *testing.T) {
+	for _, x := range []struct {
+		expr     string
+		after    string
+		expected string
+	}{
+		{"0 6 1 * *", "2019-01-15T10:00:00Z", "2019-02-01T06:00:00Z"},
+		{"0 6 1 * *", "2019-02-01T05:59:59Z", "2019-02-01T06:00:00Z"},
+		{"0 6 1 * *", "2019-02-01T06:00:00Z", "2019-03-01T06:00:00Z"},
+		{"*/15 * * * *", "2019-01-01T10:07:30Z", "2019-01-01T10:15:00Z"},
+		{"0 0 31 * *", "2019-01-31T00:00:00Z", "2019-03-31T00:00:00Z"},
+		{"0 0 29 2 *", "2019-01-01T00:00:00Z", "2020-02-29T00:00:00Z"},
+		// 2019-06-03 is a Monday
+		{"0 9 * * 1", "2019-06-01T00:00:00Z", "2019-06-03T09:00:00Z"},
+		{"0 9 * * 7", "2019-06-01T00:00:00Z", "2019-06-02T09:00:00Z"},
+		// Either the day of month or the day of week must match
+		{"0 9 15 * 1", "2019-06-04T00:00:00Z", "2019-06-10T09:00:00Z"},
+		{"@yearly", "2019-06-01T00:00:00Z", "2020-01-01T00:00:00Z"},
+	} {
+		spec, err := parseCron(x.expr)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		next, ok := spec.next(mustTime(x.after))
+		if !ok || !next.Equal(mustTime(x.expected)) {
+			t.Errorf("Next run of %q after %v should be %v. Was %v", x.expr, x.after, x.expected, next)
+		}
+	}
+
+	spec, _ := parseCron("0 0 30 2 *")
+	if next, ok := spec.next(mustTime("2019-01-01T00:00:00Z")); ok {
+		t.Errorf("Expression that never matches should have no next run. Was %v", next)
+	}
+}
+
+func TestSchedule_NextRun(t *testing.T) {
+	for _, x := range []struct {
+		schedule schedule
+		after    string
+		expected string
+	}{
+		{schedule{Every: 1, Unit: unitMonth, Anchor: mustTime("2019-01-31T08:00:00Z")}, "2019-01-01T00:00:00Z", "2019-01-31T08:00:00Z"},
+		{schedule{Every: 1, Unit: unitMonth, Anchor: mustTime("2019-01-31T08:00:00Z")}, "2019-01-31T08:00:00Z", "2019-02-28T08:00:00Z"},
+		{schedule{Every: 1, Unit: unitMonth, Anchor: mustTime("2019-01-31T08:00:00Z")}, "2019-02-28T08:00:00Z", "2019-03-31T08:00:00Z"},
+		{schedule{Every: 3, Unit: unitMonth, Anchor: mustTime("2019-01-15T00:00:00Z")}, "2019-03-20T00:00:00Z", "2019-04-15T00:00:00Z"},
+		{schedule{Every: 2, Unit: unitWeek, Anchor: mustTime("2019-01-07T00:00:00Z")}, "2019-01-21T00:00:00Z", "2019-02-04T00:00:00Z"},
+		{schedule{Every: 10, Unit: unitDay, Anchor: mustTime("2019-01-01T12:00:00Z")}, "2019-01-11T11:59:59Z", "2019-01-11T12:00:00Z"},
+		{schedule{Every: 1, Unit: unitYear, Anchor: mustTime("2020-02-29T00:00:00Z")}, "2020-03-01T00:00:00Z", "2021-02-28T00:00:00Z"},
+		{schedule{Every: 1, Unit: unitYear, Anchor: mustTime("2020-02-29T00:00:00Z")}, "2023-03-01T00:00:00Z", "2024-02-29T00:00:00Z"},
+		// Cron schedules never run before their anchor
+		{schedule{Cron: "0 6 1 * *", Anchor: mustTime("2019-03-10T00:00:00Z")}, "2019-01-01T00:00:00Z", "2019-04-01T06:00:00Z"},
+	} {
+		next, ok := x.schedule.nextRun(mustTime(x.after))
+		if !ok || !next.Equal(mustTime(x.expected)) {
+			t.Errorf("Next run of %+v after %v should be %v. Was %v", x.schedule, x.after, x.expected, next)
+		}
+	}
+}
+
+func TestSchedule_Upcoming(t *testing.T) {
+	now := mustTime("2019-06-15T00:00:00Z")
+	s := schedule{Every: 1, Unit: unitMonth, Anchor: mustTime("2019-01-01T00:00:00Z"), DueDays: 14, CatchUp: catchUpAll, NextRunAt: mustTime("2019-04-01T00:00:00Z")}
+
+	for _, x := range []struct {
+		name     string
+		catchUp  catchUpPolicy
+		paused   bool
+		expected string
+	}{
+		{"Lists missed runs to make up for", catchUpAll, false, "2019-04-01T00:00:00Z"},
+		{"Skips missed runs but the latest", catchUpLatest, false, "2019-06-01T00:00:00Z"},
+		{"Skips runs missed while paused", catchUpAll, true, "2019-07-01T00:00:00Z"},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			s := s
+			s.CatchUp = x.catchUp
+			s.Paused = x.paused
+			runs := s.upcoming(now, 3)
+			if len(runs) != 3 || !runs[0].RunAt.Equal(mustTime(x.expected)) {
+				t.Fatalf("First of %v runs should be at %v. Was %+v", 3, x.expected, runs)
+			}
+			if !runs[1].RunAt.Equal(addInterval(runs[0].RunAt, unitMonth, 1)) {
+				t.Errorf("Runs should be a month apart. Were %v and %v", runs[0].RunAt, runs[1].RunAt)
+			}
+			if !runs[0].DueDate.Equal(runs[0].RunAt.AddDate(0, 0, 14)) {
+				t.Errorf("Invoices should be due %v days after the run. Due %v", 14, runs[0].DueDate)
+			}
+		})
+	}
+}
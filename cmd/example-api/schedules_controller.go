@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultPreviewCount and maxPreviewCount bound the runs listed by previews
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+)
+
+func getSchedules(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	invalid := InvalidFieldsError{}
+
+	limit, err := parseLimit(query)
+	if err != nil {
+		invalid = append(invalid, "limit")
+	}
+	afterID, err := parseIDCursor(query)
+	if err != nil {
+		invalid = append(invalid, "cursor")
+	}
+	if len(invalid) > 0 {
+		writeInvalidFields(w, r, invalid)
+		return
+	}
+
+	ctx := r.Context()
+	page, err := scheduleModel.getPage(ctx, afterID, limit)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf("<%v>; rel=\"next\"", nextPageURL(r.URL, page.NextCursor)))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(page); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func getSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	s, err := scheduleModel.getByID(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+
+	if err := encoder.Encode(s); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func createSchedule(w http.ResponseWriter, r *http.Request) {
+	var s schedule
+	if !readJSON(w, r, &s) {
+		return
+	}
+
+	if errs := s.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := scheduleModel.create(ctx, s, time.Now().UTC())
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func deleteSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	if err := scheduleModel.delete(ctx, id); err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pauseSchedule returns a handler pausing or resuming schedules
+func pauseSchedule(paused bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := intVar(w, r, "id")
+		if !ok {
+			return
+		}
+
+		ctx := r.Context()
+		result, err := scheduleModel.setPaused(ctx, id, paused, time.Now().UTC())
+		if err != nil {
+			writeModelError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			logger.panic(r, err)
+		}
+	}
+}
+
+// previewSchedule lists the next runs of the schedule and the due dates of
+// the invoices they will create, without creating any
+func previewSchedule(w http.ResponseWriter, r *http.Request) {
+	id, ok := intVar(w, r, "id")
+	if !ok {
+		return
+	}
+
+	count := defaultPreviewCount
+	if v := r.URL.Query().Get("count"); v != "" {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 1 || c > maxPreviewCount {
+			writeInvalidFields(w, r, InvalidFieldsError{"count"})
+			return
+		}
+		count = c
+	}
+
+	ctx := r.Context()
+	s, err := scheduleModel.getByID(ctx, id)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.upcoming(time.Now().UTC(), count)); err != nil {
+		logger.panic(r, err)
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const scheduleColNames = "ID, TenantID, CustomerID, Description, Amount, Currency, TaxMode, Jurisdiction, Series, LineItems, " +
+	"Every, Unit, Cron, Anchor, DueDays, Issue, CatchUp, Paused, NextRunAt, LastRunAt"
+
+// catchUpPolicy decides which runs missed while the generator was not running
+// are made up for
+type catchUpPolicy string
+
+const (
+	catchUpAll    catchUpPolicy = "all"
+	catchUpLatest catchUpPolicy = "latest"
+)
+
+// generatorLockName names the advisory lock held while generating invoices of
+// schedules, so only one replica does so at a time
+const generatorLockName = "schedules.generateDue"
+
+// maxRunsPerPass bounds the runs of a schedule made up for by a single pass of
+// the generator. The remaining runs are made up for by the following passes
+const maxRunsPerPass = 100
+
+type schedulesModel struct {
+	db *sql.DB
+}
+
+func newSchedulesModel(db *sql.DB) schedulesModel {
+	return schedulesModel{db: db}
+}
+
+// withScheduleDefaults anchors schedules without an anchor at now, and makes
+// up for every missed run unless told otherwise
+func withScheduleDefaults(s schedule, now time.Time) schedule {
+	if s.Anchor.IsZero() {
+		s.Anchor = now
+	}
+	s.Anchor = s.Anchor.UTC().Truncate(time.Second)
+	if s.CatchUp == "" {
+		s.CatchUp = catchUpAll
+	}
+	return s
+}
+
+// invoice returns the invoice created by the run of the schedule at the given
+// time
+func (s schedule) invoice(at time.Time) invoice {
+	i := invoice{
+		CustomerID:   s.CustomerID,
+		Description:  s.Description,
+		DueDate:      at.AddDate(0, 0, s.DueDays),
+		TaxMode:      s.TaxMode,
+		Jurisdiction: s.Jurisdiction,
+		Amount:       s.Amount,
+		Currency:     s.Currency,
+		Series:       s.Series,
+		Run:          &scheduledRun{ScheduleID: s.ID, At: at},
+	}
+	for _, l := range s.Lines {
+		i.Lines = append(i.Lines, invoiceLine{
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			Category:    l.Category,
+			TaxRate:     l.TaxRate,
+		})
+	}
+	return i
+}
+
+// pendingRun returns the first run the generator will create an invoice for.
+// Runs of paused schedules are those they would have if resumed now, and of
+// schedules catching up on the latest run only the runs missed are skipped
+func (s schedule) pendingRun(now time.Time) (time.Time, bool) {
+	at := s.NextRunAt
+	if s.Paused {
+		if at.Before(now) {
+			return s.firstRun(now)
+		}
+		return at, true
+	}
+	if s.CatchUp == catchUpLatest {
+		for {
+			next, ok := s.nextRun(at)
+			if !ok || next.After(now) {
+				break
+			}
+			at = next
+		}
+	}
+	return at, true
+}
+
+// upcoming returns the next count runs of the schedule, including runs yet to
+// be made up for
+func (s schedule) upcoming(now time.Time, count int) []upcomingRun {
+	runs := []upcomingRun{}
+	at, ok := s.pendingRun(now)
+	for ; ok && len(runs) < count; at, ok = s.nextRun(at) {
+		runs = append(runs, upcomingRun{RunAt: at, DueDate: at.AddDate(0, 0, s.DueDays)})
+	}
+	return runs
+}
+
+func parseScheduleRow(scanFn func(...interface{}) error) (schedule, error) {
+	var s schedule
+	var description sql.NullString
+	var jurisdiction sql.NullString
+	var series sql.NullString
+	var lineItems sql.NullString
+	var every sql.NullInt64
+	var unit sql.NullString
+	var cron sql.NullString
+	var lastRunAt sql.NullTime
+
+	if err := scanFn(
+		&s.ID,
+		&s.TenantID,
+		&s.CustomerID,
+		&description,
+		&s.Amount,
+		&s.Currency,
+		&s.TaxMode,
+		&jurisdiction,
+		&series,
+		&lineItems,
+		&every,
+		&unit,
+		&cron,
+		&s.Anchor,
+		&s.DueDays,
+		&s.Issue,
+		&s.CatchUp,
+		&s.Paused,
+		&s.NextRunAt,
+		&lastRunAt); err != nil {
+		return schedule{}, err
+	}
+
+	s.Description = description.String
+	s.Jurisdiction = jurisdiction.String
+	s.Series = series.String
+	s.Every = int(every.Int64)
+	s.Unit = intervalUnit(unit.String)
+	s.Cron = cron.String
+	if lineItems.Valid {
+		if err := json.Unmarshal([]byte(lineItems.String), &s.Lines); err != nil {
+			return schedule{}, err
+		}
+	}
+	if lastRunAt.Valid {
+		s.LastRunAt = &lastRunAt.Time
+	}
+	return s, nil
+}
+
+func (model *schedulesModel) create(ctx context.Context, s schedule, now time.Time) (schedule, error) {
+	s = withScheduleDefaults(s, now)
+	next, ok := s.firstRun(s.Anchor)
+	if !ok {
+		return schedule{}, ValidationError{{"cron", "invalid", "Expression never matches"}}
+	}
+	if err := checkCustomer(ctx, model.db, s.CustomerID); err != nil {
+		return schedule{}, err
+	}
+
+	var lineItems sql.NullString
+	if len(s.Lines) > 0 {
+		b, err := json.Marshal(s.Lines)
+		if err != nil {
+			return schedule{}, err
+		}
+		lineItems = sql.NullString{String: string(b), Valid: true}
+	}
+
+	result, err := model.db.ExecContext(ctx,
+		"INSERT INTO schedules (TenantID, CustomerID, Description, Amount, Currency, TaxMode, Jurisdiction, Series, LineItems, "+
+			"Every, Unit, Cron, Anchor, DueDays, Issue, CatchUp, NextRunAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		tenantOf(ctx),
+		s.CustomerID,
+		sql.NullString{String: s.Description, Valid: s.Description != ""},
+		s.Amount,
+		s.Currency,
+		s.TaxMode,
+		sql.NullString{String: s.Jurisdiction, Valid: s.Jurisdiction != ""},
+		sql.NullString{String: s.Series, Valid: s.Series != ""},
+		lineItems,
+		sql.NullInt64{Int64: int64(s.Every), Valid: s.Every != 0},
+		sql.NullString{String: string(s.Unit), Valid: s.Unit != ""},
+		sql.NullString{String: s.Cron, Valid: s.Cron != ""},
+		s.Anchor,
+		s.DueDays,
+		s.Issue,
+		s.CatchUp,
+		next)
+	if isMySQLError(err, errNoReferencedRow) {
+		return schedule{}, ReferenceError(fmt.Sprintf("Customer with ID=%d not found", s.CustomerID))
+	}
+	if err != nil {
+		return schedule{}, err
+	}
+	ID, err := result.LastInsertId()
+	if err != nil {
+		return schedule{}, err
+	}
+
+	return model.getByID(ctx, int(ID))
+}
+
+// getPage returns up to limit schedules of the tenant ordered by ID, starting
+// after afterID
+func (model *schedulesModel) getPage(ctx context.Context, afterID int, limit int) (schedulePage, error) {
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v FROM schedules WHERE TenantID = ? AND ID > ? ORDER BY ID LIMIT ?", scheduleColNames),
+		tenantOf(ctx),
+		afterID,
+		limit+1)
+	if err != nil {
+		return schedulePage{}, err
+	}
+	defer rows.Close()
+
+	schedules := []schedule{}
+	for rows.Next() {
+		s, err := parseScheduleRow(rows.Scan)
+		if err != nil {
+			return schedulePage{}, err
+		}
+		schedules = append(schedules, s)
+	}
+	if err := rows.Err(); err != nil {
+		return schedulePage{}, err
+	}
+
+	page := schedulePage{Schedules: schedules}
+	if len(schedules) > limit {
+		page.Schedules = schedules[:limit]
+		page.NextCursor = encodeIDCursor(page.Schedules[limit-1].ID)
+	}
+	return page, nil
+}
+
+func (model *schedulesModel) getByID(ctx context.Context, ID int) (schedule, error) {
+	return querySchedule(ctx, model.db, ID, "")
+}
+
+func querySchedule(ctx context.Context, q queryer, ID int, lock string) (schedule, error) {
+	row := q.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT %v FROM schedules WHERE ID=? AND TenantID=? %v", scheduleColNames, lock),
+		ID,
+		tenantOf(ctx))
+	s, err := parseScheduleRow(row.Scan)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return schedule{}, NotFoundError(fmt.Sprintf("Schedule with ID=%d not found", ID))
+	case err != nil:
+		return schedule{}, err
+	default:
+		return s, nil
+	}
+}
+
+// delete removes the schedule. Invoices created by it are kept
+func (model *schedulesModel) delete(ctx context.Context, ID int) error {
+	result, err := model.db.ExecContext(ctx, "DELETE FROM schedules WHERE ID=? AND TenantID=?", ID, tenantOf(ctx))
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return NotFoundError(fmt.Sprintf("Schedule with ID=%d not found", ID))
+	}
+	return nil
+}
+
+// setPaused pauses or resumes the schedule. Runs missed while the schedule was
+// paused are skipped when it is resumed
+func (model *schedulesModel) setPaused(ctx context.Context, ID int, paused bool, now time.Time) (schedule, error) {
+	tx, err := model.db.BeginTx(ctx, nil)
+	if err != nil {
+		return schedule{}, err
+	}
+	defer tx.Rollback()
+
+	existing, err := querySchedule(ctx, tx, ID, "FOR UPDATE")
+	if err != nil {
+		return schedule{}, err
+	}
+	if existing.Paused == paused {
+		state := "running"
+		if paused {
+			state = "paused"
+		}
+		return schedule{}, ConflictError(fmt.Sprintf("Schedule with ID=%d is already %v", ID, state))
+	}
+
+	next := existing.NextRunAt
+	if !paused {
+		if at, ok := existing.pendingRun(now); ok {
+			next = at
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE schedules SET Paused=?, NextRunAt=? WHERE ID=?", paused, next, ID); err != nil {
+		return schedule{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return schedule{}, err
+	}
+
+	return model.getByID(ctx, ID)
+}
+
+// generateDue creates the invoices of the runs of every schedule due by now,
+// and returns how many were created. Runs missed while the generator was not
+// running are made up for according to the catch-up policy of each schedule.
+// When another replica holds the lock nothing is done and locked is false
+func (model *schedulesModel) generateDue(ctx context.Context, invoices *invoicesModel, now time.Time) (generated int, locked bool, err error) {
+	locked, err = withAdvisoryLock(ctx, model.db, generatorLockName, func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(ctx,
+			fmt.Sprintf("SELECT %v FROM schedules WHERE Paused=0 AND NextRunAt <= ? ORDER BY ID", scheduleColNames),
+			now)
+		if err != nil {
+			return err
+		}
+		due := []schedule{}
+		for rows.Next() {
+			s, err := parseScheduleRow(rows.Scan)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			due = append(due, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// A schedule failing to run, such as one of a deleted customer, must
+		// not hold back the others
+		var firstErr error
+		for _, s := range due {
+			n, err := model.generate(withTenant(ctx, s.TenantID), invoices, s, now)
+			generated += n
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("Schedule with ID=%d: %v", s.ID, err)
+			}
+		}
+		return firstErr
+	})
+	return generated, locked, err
+}
+
+// generate creates the invoices of the due runs of the schedule, advancing
+// the schedule past each run as its invoice is created. Schedules that never
+// run again are paused
+func (model *schedulesModel) generate(ctx context.Context, invoices *invoicesModel, s schedule, now time.Time) (int, error) {
+	generated := 0
+	at, ok := s.pendingRun(now)
+	for n := 0; ok && !at.After(now) && n < maxRunsPerPass; n++ {
+		created, err := generateRun(ctx, invoices, s, at)
+		if err != nil {
+			return generated, err
+		}
+		if created {
+			generated++
+		}
+
+		next, more := s.nextRun(at)
+		if !more {
+			next = at
+		}
+		// Resuming the schedule meanwhile moves its next run, which is kept
+		if _, err := model.db.ExecContext(ctx,
+			"UPDATE schedules SET LastRunAt=?, NextRunAt=?, Paused=Paused OR ? WHERE ID=? AND NextRunAt <= ?",
+			at, next, !more, s.ID, at); err != nil {
+			return generated, err
+		}
+		at, ok = next, more
+	}
+	return generated, nil
+}
+
+// generateRun creates the invoice of the run of the schedule at the given
+// time, issuing it if the schedule says so. If the invoice was created by an
+// interrupted earlier attempt, it is only issued and created is false
+func generateRun(ctx context.Context, invoices *invoicesModel, s schedule, at time.Time) (created bool, err error) {
+	i, err := invoices.create(ctx, s.invoice(at))
+	created = err == nil
+	if isMySQLError(err, errDupEntry) {
+		i, err = invoices.getByRun(ctx, s.ID, at)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if s.Issue && i.Status == statusDraft && i.DeletedAt == nil {
+		if _, err := invoices.transition(ctx, i.ID, statusIssued); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSearchQueryLength is the maximum number of characters of search queries
+const maxSearchQueryLength = 200
+
+// minSearchTermLength matches the default innodb_ft_min_token_size. Shorter
+// words are not indexed, so they are not highlighted either
+const minSearchTermLength = 3
+
+// snippetWords is the number of words of snippets on either side of the first
+// matching word, and maxSnippets the number of snippets of each result
+const (
+	snippetWords = 8
+	maxSnippets  = 5
+)
+
+// searchScoreCol ranks invoices by the relevance of their description plus the
+// relevance of each of their lines
+const searchScoreCol string = "MATCH(Description) AGAINST (? IN NATURAL LANGUAGE MODE) + " +
+	"(SELECT IFNULL(SUM(MATCH(l.Description) AGAINST (? IN NATURAL LANGUAGE MODE)), 0) FROM invoice_lines l WHERE l.InvoiceID = invoices.ID)"
+
+// search returns up to limit invoices whose description or lines match the
+// query, the most relevant first, along with highlighted snippets of the
+// matching texts. Deleted invoices are never found
+func (model *invoicesModel) search(ctx context.Context, query string, limit int) ([]searchResult, error) {
+	rows, err := model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT %v, %v AS Score FROM invoices WHERE TenantID=? AND DeletedAt IS NULL AND "+
+			"(MATCH(Description) AGAINST (? IN NATURAL LANGUAGE MODE) OR "+
+			"ID IN (SELECT InvoiceID FROM invoice_lines WHERE MATCH(Description) AGAINST (? IN NATURAL LANGUAGE MODE))) "+
+			"ORDER BY Score DESC, ID DESC LIMIT ?", colNames, searchScoreCol),
+		query, query, tenantOf(ctx), query, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := []searchResult{}
+	for rows.Next() {
+		var score float64
+		i, err := parseRow(func(dest ...interface{}) error {
+			return rows.Scan(append(dest, &score)...)
+		})
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		results = append(results, searchResult{Invoice: i, Score: score, Snippets: []searchSnippet{}})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	terms := searchTerms(query)
+	index := map[int]int{}
+	args := []interface{}{}
+	for n := range results {
+		r := &results[n]
+		index[r.Invoice.ID] = n
+		args = append(args, r.Invoice.ID)
+		if text, ok := highlight(r.Invoice.Description, terms); ok {
+			r.Snippets = append(r.Snippets, searchSnippet{Field: "description", Text: text})
+		}
+	}
+
+	rows, err = model.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT ID, InvoiceID, Description FROM invoice_lines WHERE InvoiceID IN (?%v) AND "+
+			"MATCH(Description) AGAINST (? IN NATURAL LANGUAGE MODE) ORDER BY InvoiceID, ID", strings.Repeat(", ?", len(args)-1)),
+		append(args, query)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l invoiceLine
+		if err := rows.Scan(&l.ID, &l.InvoiceID, &l.Description); err != nil {
+			return nil, err
+		}
+		r := &results[index[l.InvoiceID]]
+		if len(r.Snippets) >= maxSnippets {
+			continue
+		}
+		if text, ok := highlight(l.Description, terms); ok {
+			r.Snippets = append(r.Snippets, searchSnippet{Field: "lines", LineID: l.ID, Text: text})
+		}
+	}
+	return results, rows.Err()
+}
+
+// searchTerms returns the distinct words of the query in lower case, leaving
+// out words too short to be indexed
+func searchTerms(query string) map[string]bool {
+	terms := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), isNotWordRune) {
+		if utf8.RuneCountInString(word) >= minSearchTermLength {
+			terms[word] = true
+		}
+	}
+	return terms
+}
+
+func isNotWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight returns the snippet of text around the first word matching one
+// of the terms, or false if no word does. The snippet is HTML escaped, and
+// the matching words are marked with <mark> elements
+func highlight(text string, terms map[string]bool) (string, bool) {
+	type span struct {
+		start int
+		end   int
+		match bool
+	}
+	words := []span{}
+	first := -1
+	start := -1
+	for i, r := range text + " " {
+		switch {
+		case !isNotWordRune(r) && start < 0:
+			start = i
+		case isNotWordRune(r) && start >= 0:
+			match := terms[strings.ToLower(text[start:i])]
+			if match && first < 0 {
+				first = len(words)
+			}
+			words = append(words, span{start, i, match})
+			start = -1
+		}
+	}
+	if first < 0 {
+		return "", false
+	}
+
+	from := first - snippetWords
+	if from < 0 {
+		from = 0
+	}
+	to := first + snippetWords + 1
+	if to > len(words) {
+		to = len(words)
+	}
+
+	var b strings.Builder
+	pos := 0
+	if from > 0 {
+		b.WriteString("…")
+		pos = words[from].start
+	}
+	for _, w := range words[from:to] {
+		b.WriteString(html.EscapeString(text[pos:w.start]))
+		if w.match {
+			b.WriteString("<mark>" + html.EscapeString(text[w.start:w.end]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(text[w.start:w.end]))
+		}
+		pos = w.end
+	}
+	if to < len(words) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(html.EscapeString(text[pos:]))
+	}
+	return b.String(), true
+}
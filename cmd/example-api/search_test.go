@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	for _, x := range []struct {
+		text     string
+		query    string
+		expected string
+	}{
+		{"Annual consulting retainer", "consulting", "Annual <mark>consulting</mark> retainer"},
+		{"Consulting, March", "CONSULTING march", "<mark>Consulting</mark>, <mark>March</mark>"},
+		{"(Server) rack & cables", "server", "(<mark>Server</mark>) rack &amp; cables"},
+		{"one two three four five six seven eight nine ten rack eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen nineteen",
+			"rack",
+			"…three four five six seven eight nine ten <mark>rack</mark> eleven twelve thirteen fourteen fifteen sixteen seventeen eighteen…"},
+		{"Rack of servers", "on a rack", "<mark>Rack</mark> of servers"},
+	} {
+		if snippet, ok := highlight(x.text, searchTerms(x.query)); !ok || snippet != x.expected {
+			t.Errorf("Should highlight %q in %q as %q. Was %q", x.query, x.text, x.expected, snippet)
+		}
+	}
+
+	for _, x := range []struct {
+		text  string
+		query string
+	}{
+		{"Annual consulting retainer", "consult"},
+		{"Server rack", "on"},
+		{"", "server"},
+	} {
+		if snippet, ok := highlight(x.text, searchTerms(x.query)); ok {
+			t.Errorf("Should not highlight %q in %q. Highlighted %q", x.query, x.text, snippet)
+		}
+	}
+}
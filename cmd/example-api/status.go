@@ -0,0 +1,36 @@
+package main
+
+// invoiceStatus represents the stage of an invoice in its lifecycle
+type invoiceStatus string
+
+const (
+	statusDraft   invoiceStatus = "draft"
+	statusIssued  invoiceStatus = "issued"
+	statusPaid    invoiceStatus = "paid"
+	statusOverdue invoiceStatus = "overdue"
+	statusVoid    invoiceStatus = "void"
+)
+
+// invoiceTransitions lists the statuses an invoice may move to from each status.
+// Paid and void are final.
+var invoiceTransitions = map[invoiceStatus][]invoiceStatus{
+	statusDraft:   {statusIssued, statusVoid},
+	statusIssued:  {statusPaid, statusOverdue, statusVoid},
+	statusOverdue: {statusPaid, statusVoid},
+	statusPaid:    {},
+	statusVoid:    {},
+}
+
+func (s invoiceStatus) valid() bool {
+	_, ok := invoiceTransitions[s]
+	return ok
+}
+
+func (s invoiceStatus) canTransitionTo(to invoiceStatus) bool {
+	for _, allowed := range invoiceTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
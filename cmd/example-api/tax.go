@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// taxMode tells whether the unit prices of invoice lines include tax
+type taxMode string
+
+const (
+	taxExclusive taxMode = "exclusive"
+	taxInclusive taxMode = "inclusive"
+)
+
+// taxBreakdown is the tax of the lines of an invoice, computed by computeTax
+type taxBreakdown struct {
+	// lineNet holds the net amount of each line
+	lineNet []decimal
+	net     decimal
+	tax     decimal
+	gross   decimal
+	summary []taxSummary
+}
+
+// withTaxDefaults returns the invoice with exclusive tax in the configured
+// jurisdiction, unless the invoice states otherwise
+func withTaxDefaults(i invoice) invoice {
+	if i.TaxMode == "" {
+		i.TaxMode = taxExclusive
+	}
+	if i.Jurisdiction == "" {
+		i.Jurisdiction = config.tax.jurisdiction
+	}
+	return i
+}
+
+// computeTax computes the tax of the lines per rate, as required by EN 16931,
+// with amounts rounded to the given number of decimal places. In exclusive
+// mode the tax of each rate is added to the sum of its net line amounts. In
+// inclusive mode the unit prices include tax, which is extracted from each
+// line, and the tax of a rate is whatever remains of its gross amount once
+// the net line amounts are subtracted
+func computeTax(lines []invoiceLine, mode taxMode, minorUnits int) taxBreakdown {
+	b := taxBreakdown{lineNet: make([]decimal, len(lines))}
+	byRate := map[int64]*taxSummary{}
+	rates := []int64{}
+	for n, l := range lines {
+		s, ok := byRate[l.TaxRate.units]
+		if !ok {
+			s = &taxSummary{Rate: l.TaxRate}
+			byRate[l.TaxRate.units] = s
+			rates = append(rates, l.TaxRate.units)
+		}
+
+		amount := l.Quantity.mul(l.UnitPrice).round(minorUnits)
+		if mode == taxInclusive {
+			b.lineNet[n] = amount.div(newDecimal(1).add(l.TaxRate)).round(minorUnits)
+			s.Gross = s.Gross.add(amount)
+		} else {
+			b.lineNet[n] = amount
+		}
+		s.Net = s.Net.add(b.lineNet[n])
+	}
+
+	sort.Slice(rates, func(a, b int) bool { return rates[a] < rates[b] })
+	for _, units := range rates {
+		s := byRate[units]
+		if mode == taxInclusive {
+			s.Tax = s.Gross.sub(s.Net)
+		} else {
+			s.Tax = s.Net.mul(s.Rate).round(minorUnits)
+			s.Gross = s.Net.add(s.Tax)
+		}
+		b.net = b.net.add(s.Net)
+		b.tax = b.tax.add(s.Tax)
+		b.gross = b.gross.add(s.Gross)
+		b.summary = append(b.summary, *s)
+	}
+	return b
+}
+
+// lineTaxRate returns the tax rate of the line. Lines with a category get the
+// rate of the tenant for the category in the jurisdiction of the invoice at the
+// time the line is stored, so later changes to the rate tables do not alter
+// the invoice
+func lineTaxRate(ctx context.Context, q queryer, invoiceID int, l invoiceLine) (decimal, error) {
+	if l.Category == "" {
+		return l.TaxRate, nil
+	}
+
+	var rate decimal
+	err := q.QueryRowContext(ctx,
+		"SELECT r.Rate FROM tax_rates r JOIN invoices i ON i.Jurisdiction = r.Jurisdiction AND r.TenantID IN (i.TenantID, ?) "+
+			"WHERE i.ID=? AND i.TenantID=? AND r.Category=? ORDER BY r.TenantID = ? LIMIT 1",
+		sharedTaxRates,
+		invoiceID,
+		tenantOf(ctx),
+		l.Category,
+		sharedTaxRates).Scan(&rate)
+	if err == sql.ErrNoRows {
+		return decimal{}, ReferenceError(fmt.Sprintf("Tax category %q not found in the jurisdiction of invoice with ID=%d", l.Category, invoiceID))
+	}
+	return rate, err
+}
+
+// updateLineRates sets the tax rate of the lines with a category to the rate
+// of the category in the current jurisdiction of the invoice
+func updateLineRates(ctx context.Context, q queryer, invoiceID int) error {
+	lines, err := getLines(ctx, q, invoiceID)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if l.Category == "" {
+			continue
+		}
+		rate, err := lineTaxRate(ctx, q, invoiceID, l)
+		if err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, "UPDATE invoice_lines SET TaxRate=? WHERE ID=?", rate, l.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
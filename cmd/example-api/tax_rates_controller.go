@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func getTaxRates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rates, err := taxRateModel.getAll(ctx, r.URL.Query().Get("jurisdiction"))
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(rates); err != nil {
+		logger.panic(r, err)
+	}
+}
+
+func putTaxRate(w http.ResponseWriter, r *http.Request) {
+	var rate taxRate
+	if !readJSON(w, r, &rate) {
+		return
+	}
+	vars := mux.Vars(r)
+	rate.Jurisdiction = vars["jurisdiction"]
+	rate.Category = vars["category"]
+
+	if errs := rate.validate(); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	ctx := r.Context()
+	result, err := taxRateModel.put(ctx, rate)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		logger.panic(r, err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+type taxRatesModel struct {
+	db *sql.DB
+}
+
+func newTaxRatesModel(db *sql.DB) taxRatesModel {
+	return taxRatesModel{db: db}
+}
+
+// sharedTaxRates is the tenant of the default rates of every tenant. Tenants
+// override the default rate of a category with a rate of their own
+const sharedTaxRates = ""
+
+// getAll returns the tax rates of the tenant in the jurisdiction, or in every
+// jurisdiction when jurisdiction is empty
+func (model *taxRatesModel) getAll(ctx context.Context, jurisdiction string) ([]taxRate, error) {
+	rows, err := model.db.QueryContext(ctx,
+		"SELECT Jurisdiction, Category, Rate FROM tax_rates r WHERE (? = '' OR Jurisdiction = ?) AND (TenantID = ? OR "+
+			"(TenantID = ? AND NOT EXISTS (SELECT 1 FROM tax_rates t WHERE t.TenantID = ? AND t.Jurisdiction = r.Jurisdiction AND t.Category = r.Category))) "+
+			"ORDER BY Jurisdiction, Category",
+		jurisdiction,
+		jurisdiction,
+		tenantOf(ctx),
+		sharedTaxRates,
+		tenantOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []taxRate{}
+	for rows.Next() {
+		var r taxRate
+		if err := rows.Scan(&r.Jurisdiction, &r.Category, &r.Rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// put sets the rate of the category in the jurisdiction for the tenant, adding
+// the category if needed. Lines already stored keep the rate they were given
+func (model *taxRatesModel) put(ctx context.Context, r taxRate) (taxRate, error) {
+	_, err := model.db.ExecContext(ctx,
+		"INSERT INTO tax_rates (TenantID, Jurisdiction, Category, Rate) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE Rate=VALUES(Rate)",
+		tenantOf(ctx),
+		r.Jurisdiction,
+		r.Category,
+		r.Rate)
+	if err != nil {
+		return taxRate{}, err
+	}
+	return r, nil
+}
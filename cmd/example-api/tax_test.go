@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestComputeTax(t *testing.T) {
+	lines := []invoiceLine{
+		{Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25")},
+		{Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25")},
+		{Quantity: mustDecimal("3"), UnitPrice: mustDecimal("33.33"), TaxRate: mustDecimal("0.15")},
+		{Quantity: mustDecimal("2"), UnitPrice: mustDecimal("50")},
+	}
+
+	for _, x := range []struct {
+		mode    taxMode
+		net     string
+		tax     string
+		gross   string
+		summary []taxSummary
+	}{
+		{
+			mode:  taxExclusive,
+			net:   "202.01",
+			tax:   "15.51",
+			gross: "217.52",
+			summary: []taxSummary{
+				{Rate: mustDecimal("0"), Net: mustDecimal("100"), Tax: mustDecimal("0"), Gross: mustDecimal("100")},
+				{Rate: mustDecimal("0.15"), Net: mustDecimal("99.99"), Tax: mustDecimal("15"), Gross: mustDecimal("114.99")},
+				{Rate: mustDecimal("0.25"), Net: mustDecimal("2.02"), Tax: mustDecimal("0.51"), Gross: mustDecimal("2.53")},
+			},
+		},
+		{
+			mode:  taxInclusive,
+			net:   "188.57",
+			tax:   "13.44",
+			gross: "202.01",
+			summary: []taxSummary{
+				{Rate: mustDecimal("0"), Net: mustDecimal("100"), Tax: mustDecimal("0"), Gross: mustDecimal("100")},
+				{Rate: mustDecimal("0.15"), Net: mustDecimal("86.95"), Tax: mustDecimal("13.04"), Gross: mustDecimal("99.99")},
+				{Rate: mustDecimal("0.25"), Net: mustDecimal("1.62"), Tax: mustDecimal("0.4"), Gross: mustDecimal("2.02")},
+			},
+		},
+	} {
+		t.Run(string(x.mode), func(t *testing.T) {
+			b := computeTax(lines, x.mode, 2)
+			if b.net.String() != x.net || b.tax.String() != x.tax || b.gross.String() != x.gross {
+				t.Errorf("Expected net %v, tax %v and gross %v. Got %v, %v and %v", x.net, x.tax, x.gross, b.net, b.tax, b.gross)
+			}
+			if len(b.summary) != len(x.summary) {
+				t.Fatalf("Expected %v rates in summary, got %v", len(x.summary), len(b.summary))
+			}
+			for n, s := range b.summary {
+				if s != x.summary[n] {
+					t.Errorf("Expected summary %+v, got %+v", x.summary[n], s)
+				}
+			}
+
+			lineSum := decimal{}
+			for _, net := range b.lineNet {
+				lineSum = lineSum.add(net)
+			}
+			if lineSum != b.net {
+				t.Errorf("Net line amounts should add up to net amount %v. Add up to %v", b.net, lineSum)
+			}
+		})
+	}
+}
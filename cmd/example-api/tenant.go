@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"regexp"
+)
+
+// tenantClaim is the JWT claim naming the tenant the caller acts for
+const tenantClaim = "tenant"
+
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+type tenantContextKey string
+
+var ctxKeyTenant tenantContextKey = tenantContextKey("tenant")
+
+// withTenant returns a context scoping queries to the tenant
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, ctxKeyTenant, tenant)
+}
+
+// tenantOf returns the tenant put on the context by checkAuthorization or
+// withTenant. Without a tenant, the context scopes queries to no rows
+func tenantOf(ctx context.Context) string {
+	tenant, _ := ctx.Value(ctxKeyTenant).(string)
+	return tenant
+}
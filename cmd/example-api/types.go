@@ -1,24 +1,252 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 )
 
 // Invoice represents invoices sent to customers
 type invoice struct {
-	ID          int       `json:"id"`
-	CustomerID  int       `json:"customerID"`
-	Description string    `json:"description,omitempty"`
-	DueDate     time.Time `json:"dueDate,omitempty"`
-	Amount      float64   `json:"amount"`
+	ID           int           `json:"id"`
+	Number       string        `json:"invoiceNumber,omitempty"`
+	Series       string        `json:"-"`
+	CustomerID   int           `json:"customerID"`
+	Description  string        `json:"description,omitempty"`
+	DueDate      time.Time     `json:"dueDate,omitempty"`
+	IssuedAt     *time.Time    `json:"issuedAt,omitempty"`
+	TaxMode      taxMode       `json:"taxMode"`
+	Jurisdiction string        `json:"jurisdiction,omitempty"`
+	NetAmount    decimal       `json:"netAmount"`
+	TaxAmount    decimal       `json:"taxAmount"`
+	Amount       decimal       `json:"amount"`
+	Credited     decimal       `json:"credited"`
+	Balance      decimal       `json:"balance"`
+	Currency     string        `json:"currency"`
+	Status       invoiceStatus `json:"status"`
+	Lines        []invoiceLine `json:"lines,omitempty"`
+	TaxSummary   []taxSummary  `json:"taxSummary,omitempty"`
+	Version      int           `json:"-"`
+	DeletedAt    *time.Time    `json:"deletedAt,omitempty"`
+	Run          *scheduledRun `json:"-"`
+}
+
+// invoiceLine represents a line item of an invoice
+type invoiceLine struct {
+	ID          int     `json:"id"`
+	InvoiceID   int     `json:"invoiceID"`
+	Description string  `json:"description"`
+	Quantity    decimal `json:"quantity"`
+	UnitPrice   decimal `json:"unitPrice"`
+	Category    string  `json:"category,omitempty"`
+	TaxRate     decimal `json:"taxRate"`
+	Amount      decimal `json:"amount"`
+}
+
+// taxSummary is the tax of the lines of an invoice at a single rate
+type taxSummary struct {
+	Rate  decimal `json:"rate"`
+	Net   decimal `json:"net"`
+	Tax   decimal `json:"tax"`
+	Gross decimal `json:"gross"`
+}
+
+// taxRate is the rate of tax on a category of products in a jurisdiction
+type taxRate struct {
+	Jurisdiction string  `json:"jurisdiction"`
+	Category     string  `json:"category"`
+	Rate         decimal `json:"rate"`
+}
+
+// payment represents money received against an invoice
+type payment struct {
+	ID         int       `json:"id"`
+	InvoiceID  int       `json:"invoiceID"`
+	Amount     decimal   `json:"amount"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	Reference  string    `json:"reference,omitempty"`
+}
+
+// creditNote represents an amount credited against an issued invoice
+type creditNote struct {
+	ID        int       `json:"id"`
+	Number    string    `json:"number"`
+	InvoiceID int       `json:"invoiceID"`
+	Amount    decimal   `json:"amount"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// schedule represents a recurring invoice. On every run an invoice is created
+// from the invoice fields of the schedule. Schedules run either at a fixed
+// interval from their anchor, or at the times matching a cron expression
+type schedule struct {
+	ID           int            `json:"id"`
+	TenantID     string         `json:"-"`
+	CustomerID   int            `json:"customerID"`
+	Description  string         `json:"description,omitempty"`
+	Amount       decimal        `json:"amount"`
+	Currency     string         `json:"currency"`
+	TaxMode      taxMode        `json:"taxMode,omitempty"`
+	Jurisdiction string         `json:"jurisdiction,omitempty"`
+	Series       string         `json:"series,omitempty"`
+	Lines        []scheduleLine `json:"lines,omitempty"`
+	Every        int            `json:"every,omitempty"`
+	Unit         intervalUnit   `json:"unit,omitempty"`
+	Cron         string         `json:"cron,omitempty"`
+	Anchor       time.Time      `json:"anchor"`
+	DueDays      int            `json:"dueDays"`
+	Issue        bool           `json:"issue"`
+	CatchUp      catchUpPolicy  `json:"catchUp"`
+	Paused       bool           `json:"paused"`
+	NextRunAt    time.Time      `json:"nextRunAt"`
+	LastRunAt    *time.Time     `json:"lastRunAt,omitempty"`
+}
+
+// scheduleLine is a line item of the invoices created by a schedule
+type scheduleLine struct {
+	Description string  `json:"description"`
+	Quantity    decimal `json:"quantity"`
+	UnitPrice   decimal `json:"unitPrice"`
+	Category    string  `json:"category,omitempty"`
+	TaxRate     decimal `json:"taxRate"`
+}
+
+// scheduledRun identifies the run of a schedule an invoice was created by
+type scheduledRun struct {
+	ScheduleID int
+	At         time.Time
+}
+
+// upcomingRun represents a future run of a schedule
+type upcomingRun struct {
+	RunAt   time.Time `json:"runAt"`
+	DueDate time.Time `json:"dueDate"`
+}
+
+// customer represents the recipient of invoices
+type customer struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// bulkResult represents the outcome of importing a single row
+type bulkResult struct {
+	Row    int             `json:"row"`
+	Status string          `json:"status"`
+	ID     int             `json:"id,omitempty"`
+	Detail string          `json:"detail,omitempty"`
+	Errors ValidationError `json:"errors,omitempty"`
+}
+
+// bulkReport represents the outcome of a bulk import
+type bulkReport struct {
+	Atomic  bool         `json:"atomic"`
+	Created int          `json:"created"`
+	Failed  int          `json:"failed"`
+	Results []bulkResult `json:"results"`
+}
+
+// auditEvent represents a recorded change of an invoice
+type auditEvent struct {
+	ID            int             `json:"id"`
+	InvoiceID     int             `json:"invoiceID"`
+	Action        string          `json:"action"`
+	Actor         string          `json:"actor"`
+	CorrelationID string          `json:"correlationID,omitempty"`
+	Version       int             `json:"version"`
+	Before        json.RawMessage `json:"before,omitempty"`
+	After         json.RawMessage `json:"after"`
+	CreatedAt     time.Time       `json:"createdAt"`
 }
 
 // Invoices represents a list of invoices
 type Invoices []invoice
 
+// InvoicePage represents a page of invoices and the cursor to the next page
+type invoicePage struct {
+	Invoices   []invoice `json:"invoices"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+// searchResult represents an invoice found by a full-text search, with
+// snippets of the texts of the invoice matching the search
+type searchResult struct {
+	Invoice  invoice         `json:"invoice"`
+	Score    float64         `json:"score"`
+	Snippets []searchSnippet `json:"snippets"`
+}
+
+// searchSnippet is an excerpt of the description of an invoice or of one of
+// its lines, with the words matching the search marked
+type searchSnippet struct {
+	Field  string `json:"field"`
+	LineID int    `json:"lineID,omitempty"`
+	Text   string `json:"text"`
+}
+
+// searchResults represents the invoices found by a full-text search, the most
+// relevant first
+type searchResults struct {
+	Results []searchResult `json:"results"`
+}
+
+// customerPage represents a page of customers and the cursor to the next page
+type customerPage struct {
+	Customers  []customer `json:"customers"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// schedulePage represents a page of schedules and the cursor to the next page
+type schedulePage struct {
+	Schedules  []schedule `json:"schedules"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
 // NotFoundError represents an item not found error
 type NotFoundError string
 
 func (e NotFoundError) Error() string {
 	return string(e)
 }
+
+// ReferenceError represents a reference to an item that does not exist
+type ReferenceError string
+
+func (e ReferenceError) Error() string {
+	return string(e)
+}
+
+// ConflictError represents a change conflicting with the current state of an item
+type ConflictError string
+
+func (e ConflictError) Error() string {
+	return string(e)
+}
+
+// PreconditionFailedError represents a change to an item modified since the client read it
+type PreconditionFailedError string
+
+func (e PreconditionFailedError) Error() string {
+	return string(e)
+}
+
+// InvalidFieldsError represents a list of invalid query parameters or fields
+type InvalidFieldsError []string
+
+func (e InvalidFieldsError) Error() string {
+	return "Invalid fields: " + strings.Join(e, ", ")
+}
+
+// InvalidTransitionError represents a status change not allowed from the current status
+type InvalidTransitionError struct {
+	from invoiceStatus
+	to   invoiceStatus
+}
+
+func (e InvalidTransitionError) Error() string {
+	return fmt.Sprintf("Invoice with status=%v cannot be changed to status=%v", e.from, e.to)
+}
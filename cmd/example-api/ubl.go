@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const mediaTypeXML = "application/xml"
+
+const (
+	ublNamespaceInvoice = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	ublNamespaceCAC     = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	ublNamespaceCBC     = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+
+	peppolCustomizationID = "urn:cen.eu:en16931:2017#compliant#urn:fdc:peppol.eu:2017:poacc:billing:3.0"
+	peppolProfileID       = "urn:fdc:peppol.eu:2017:poacc:billing:01:1.0"
+
+	// ublInvoiceTypeCode is the UNCL1001 code of commercial invoices
+	ublInvoiceTypeCode = "380"
+	// ublUnitCode is the UN/ECE Recommendation 20 code for "one", used as
+	// the unit of all quantities
+	ublUnitCode = "C62"
+)
+
+// ublInvoice is a UBL 2.1 Invoice document following the PEPPOL BIS Billing 3.0
+// profile. The fields are declared in the order required by the UBL schema
+type ublInvoice struct {
+	XMLName                 xml.Name         `xml:"urn:oasis:names:specification:ubl:schema:xsd:Invoice-2 Invoice"`
+	CAC                     string           `xml:"xmlns:cac,attr"`
+	CBC                     string           `xml:"xmlns:cbc,attr"`
+	CustomizationID         string           `xml:"cbc:CustomizationID"`
+	ProfileID               string           `xml:"cbc:ProfileID"`
+	ID                      string           `xml:"cbc:ID"`
+	IssueDate               string           `xml:"cbc:IssueDate"`
+	DueDate                 string           `xml:"cbc:DueDate,omitempty"`
+	InvoiceTypeCode         string           `xml:"cbc:InvoiceTypeCode"`
+	Note                    string           `xml:"cbc:Note,omitempty"`
+	DocumentCurrencyCode    string           `xml:"cbc:DocumentCurrencyCode"`
+	AccountingSupplierParty ublParty         `xml:"cac:AccountingSupplierParty>cac:Party"`
+	AccountingCustomerParty ublParty         `xml:"cac:AccountingCustomerParty>cac:Party"`
+	TaxTotal                ublTaxTotal      `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal      ublMonetaryTotal `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines            []ublInvoiceLine `xml:"cac:InvoiceLine"`
+}
+
+type ublParty struct {
+	EndpointID       *ublIdentifier    `xml:"cbc:EndpointID,omitempty"`
+	PartyName        string            `xml:"cac:PartyName>cbc:Name"`
+	PostalAddress    *ublPostalAddress `xml:"cac:PostalAddress,omitempty"`
+	RegistrationName string            `xml:"cac:PartyLegalEntity>cbc:RegistrationName"`
+	ElectronicMail   string            `xml:"cac:Contact>cbc:ElectronicMail,omitempty"`
+}
+
+type ublIdentifier struct {
+	SchemeID string `xml:"schemeID,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ublPostalAddress struct {
+	StreetName string `xml:"cbc:StreetName,omitempty"`
+	Country    string `xml:"cac:Country>cbc:IdentificationCode"`
+}
+
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount    ublAmount        `xml:"cbc:TaxAmount"`
+	TaxSubtotals []ublTaxSubtotal `xml:"cac:TaxSubtotal"`
+}
+
+type ublTaxSubtotal struct {
+	TaxableAmount ublAmount      `xml:"cbc:TaxableAmount"`
+	TaxAmount     ublAmount      `xml:"cbc:TaxAmount"`
+	TaxCategory   ublTaxCategory `xml:"cac:TaxCategory"`
+}
+
+type ublTaxCategory struct {
+	ID        string `xml:"cbc:ID"`
+	Percent   string `xml:"cbc:Percent"`
+	TaxScheme string `xml:"cac:TaxScheme>cbc:ID"`
+}
+
+type ublMonetaryTotal struct {
+	LineExtensionAmount   ublAmount  `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount    ublAmount  `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount    ublAmount  `xml:"cbc:TaxInclusiveAmount"`
+	PrepaidAmount         *ublAmount `xml:"cbc:PrepaidAmount,omitempty"`
+	PayableRoundingAmount *ublAmount `xml:"cbc:PayableRoundingAmount,omitempty"`
+	PayableAmount         ublAmount  `xml:"cbc:PayableAmount"`
+}
+
+type ublInvoiceLine struct {
+	ID                    string         `xml:"cbc:ID"`
+	InvoicedQuantity      ublQuantity    `xml:"cbc:InvoicedQuantity"`
+	LineExtensionAmount   ublAmount      `xml:"cbc:LineExtensionAmount"`
+	ItemName              string         `xml:"cac:Item>cbc:Name"`
+	ClassifiedTaxCategory ublTaxCategory `xml:"cac:Item>cac:ClassifiedTaxCategory"`
+	PriceAmount           ublAmount      `xml:"cac:Price>cbc:PriceAmount"`
+}
+
+type ublQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// newUBLInvoice maps the invoice document to UBL. Drafts and invoices issued
+// before the issue date was stored are dated now. The customer is addressed
+// in the jurisdiction of the invoice, as customers have no country of their
+// own. An invoice without lines is represented by a single zero rated line
+// for its amount, as UBL requires at least one line. Invoices without lines
+// whose amount includes tax are rejected, as the rate of the tax is unknown
+func newUBLInvoice(d invoiceDocument, now time.Time) (ublInvoice, error) {
+	i := d.invoice
+	if len(i.Lines) == 0 && (i.TaxMode == taxInclusive || !i.TaxAmount.isZero()) {
+		return ublInvoice{}, ConflictError(fmt.Sprintf("Invoice with ID=%d has no lines stating the tax included in its amount", i.ID))
+	}
+	issueDate := now
+	if i.IssuedAt != nil {
+		issueDate = *i.IssuedAt
+	}
+	country := i.Jurisdiction
+	if country == "" {
+		country = config.tax.jurisdiction
+	}
+	minorUnits := currencyMinorUnits[i.Currency]
+	amount := func(a decimal) ublAmount {
+		return ublAmount{CurrencyID: i.Currency, Value: a.fixed(minorUnits)}
+	}
+	category := func(rate decimal) ublTaxCategory {
+		id := "S"
+		if rate.isZero() {
+			id = "Z"
+		}
+		return ublTaxCategory{ID: id, Percent: rate.mul(newDecimal(100)).String(), TaxScheme: "VAT"}
+	}
+
+	lines := i.Lines
+	if len(lines) == 0 {
+		lines = []invoiceLine{{Description: i.Description, Quantity: newDecimal(1), UnitPrice: i.Amount}}
+		if lines[0].Description == "" {
+			lines[0].Description = "Invoice " + d.number()
+		}
+	}
+
+	u := ublInvoice{
+		CAC:                  ublNamespaceCAC,
+		CBC:                  ublNamespaceCBC,
+		CustomizationID:      peppolCustomizationID,
+		ProfileID:            peppolProfileID,
+		ID:                   d.number(),
+		IssueDate:            issueDate.Format("2006-01-02"),
+		InvoiceTypeCode:      ublInvoiceTypeCode,
+		Note:                 i.Description,
+		DocumentCurrencyCode: i.Currency,
+		AccountingSupplierParty: ublParty{
+			PartyName:        config.supplier.name,
+			PostalAddress:    &ublPostalAddress{Country: config.supplier.country},
+			RegistrationName: config.supplier.name,
+		},
+		AccountingCustomerParty: ublParty{
+			PartyName:        d.customer.Name,
+			PostalAddress:    &ublPostalAddress{StreetName: d.customer.Address, Country: country},
+			RegistrationName: d.customer.Name,
+			ElectronicMail:   d.customer.Email,
+		},
+	}
+	if !i.DueDate.IsZero() {
+		u.DueDate = i.DueDate.Format("2006-01-02")
+	}
+	if config.supplier.endpointID != "" {
+		u.AccountingSupplierParty.EndpointID = &ublIdentifier{SchemeID: config.supplier.endpointScheme, Value: config.supplier.endpointID}
+	}
+	if d.customer.Email != "" {
+		u.AccountingCustomerParty.EndpointID = &ublIdentifier{SchemeID: "EM", Value: d.customer.Email}
+	}
+
+	// Tax is computed per rate on the sum of the net line amounts, as required
+	// by EN 16931. UBL prices exclude tax, so the prices of invoices in
+	// inclusive mode are converted
+	b := computeTax(lines, i.TaxMode, minorUnits)
+	for n, l := range lines {
+		price := l.UnitPrice
+		if i.TaxMode == taxInclusive {
+			price = price.div(newDecimal(1).add(l.TaxRate))
+		}
+		u.InvoiceLines = append(u.InvoiceLines, ublInvoiceLine{
+			ID:                    fmt.Sprint(n + 1),
+			InvoicedQuantity:      ublQuantity{UnitCode: ublUnitCode, Value: l.Quantity.String()},
+			LineExtensionAmount:   amount(b.lineNet[n]),
+			ItemName:              l.Description,
+			ClassifiedTaxCategory: category(l.TaxRate),
+			PriceAmount:           ublAmount{CurrencyID: i.Currency, Value: price.String()},
+		})
+	}
+	for _, s := range b.summary {
+		u.TaxTotal.TaxSubtotals = append(u.TaxTotal.TaxSubtotals, ublTaxSubtotal{
+			TaxableAmount: amount(s.Net),
+			TaxAmount:     amount(s.Tax),
+			TaxCategory:   category(s.Rate),
+		})
+	}
+	u.TaxTotal.TaxAmount = amount(b.tax)
+
+	// The amounts of invoices created before tax was computed per rate may
+	// differ slightly from the totals computed here. The difference is stated
+	// as rounding. Payments and credit notes are stated as prepaid, leaving
+	// the balance payable
+	prepaid := i.Amount.sub(i.Balance).round(minorUnits)
+	u.LegalMonetaryTotal = ublMonetaryTotal{
+		LineExtensionAmount: amount(b.net),
+		TaxExclusiveAmount:  amount(b.net),
+		TaxInclusiveAmount:  amount(b.gross),
+		PayableAmount:       amount(i.Amount.round(minorUnits).sub(prepaid)),
+	}
+	if !prepaid.isZero() {
+		p := amount(prepaid)
+		u.LegalMonetaryTotal.PrepaidAmount = &p
+	}
+	if rounding := i.Amount.round(minorUnits).sub(b.gross); !rounding.isZero() {
+		r := amount(rounding)
+		u.LegalMonetaryTotal.PayableRoundingAmount = &r
+	}
+	return u, nil
+}
+
+// writeInvoiceUBL responds with the invoice as a UBL Invoice document
+func writeInvoiceUBL(w http.ResponseWriter, r *http.Request, i invoice) {
+	ctx := r.Context()
+	c, err := customerModel.getByID(ctx, i.CustomerID)
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+
+	u, err := newUBLInvoice(invoiceDocument{invoice: i, customer: c}, time.Now())
+	if err != nil {
+		writeModelError(w, r, err)
+		return
+	}
+	body, err := xml.MarshalIndent(u, "", "  ")
+	if err != nil {
+		logger.panic(r, err)
+	}
+
+	w.Header().Set("Content-Type", mediaTypeXML+"; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append([]byte(xml.Header), body...)); err != nil {
+		logger.error(r, err)
+	}
+}
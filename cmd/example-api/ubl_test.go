@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ublInvoiceSequence lists the children of the UBL 2.1 Invoice element in the
+// order required by the schema
+var ublInvoiceSequence = []string{
+	"UBLExtensions", "UBLVersionID", "CustomizationID", "ProfileID", "ProfileExecutionID", "ID",
+	"CopyIndicator", "UUID", "IssueDate", "IssueTime", "DueDate", "InvoiceTypeCode", "Note",
+	"TaxPointDate", "DocumentCurrencyCode", "TaxCurrencyCode", "PricingCurrencyCode",
+	"PaymentCurrencyCode", "PaymentAlternativeCurrencyCode", "AccountingCostCode", "AccountingCost",
+	"LineCountNumeric", "BuyerReference", "InvoicePeriod", "OrderReference", "BillingReference",
+	"DespatchDocumentReference", "ReceiptDocumentReference", "StatementDocumentReference",
+	"OriginatorDocumentReference", "ContractDocumentReference", "AdditionalDocumentReference",
+	"ProjectReference", "Signature", "AccountingSupplierParty", "AccountingCustomerParty",
+	"PayeeParty", "BuyerCustomerParty", "SellerSupplierParty", "TaxRepresentativeParty", "Delivery",
+	"DeliveryTerms", "PaymentMeans", "PaymentTerms", "PrepaidPayment", "AllowanceCharge",
+	"TaxExchangeRate", "PricingExchangeRate", "PaymentExchangeRate", "PaymentAlternativeExchangeRate",
+	"TaxTotal", "WithholdingTaxTotal", "LegalMonetaryTotal", "InvoiceLine",
+}
+
+// ublSequences lists the children of the aggregates used in invoices, in the
+// order required by the schema. Aggregates not listed are not checked
+var ublSequences = map[string][]string{
+	"Invoice":                 ublInvoiceSequence,
+	"AccountingSupplierParty": {"CustomerAssignedAccountID", "AdditionalAccountID", "DataSendingCapability", "Party", "DespatchContact", "AccountingContact", "SellerContact"},
+	"AccountingCustomerParty": {"CustomerAssignedAccountID", "SupplierAssignedAccountID", "AdditionalAccountID", "Party", "DeliveryContact", "AccountingContact", "BuyerContact"},
+	"Party": {
+		"MarkCareIndicator", "MarkAttentionIndicator", "WebsiteURI", "LogoReferenceID", "EndpointID",
+		"IndustryClassificationCode", "PartyIdentification", "PartyName", "Language", "PostalAddress",
+		"PhysicalLocation", "PartyTaxScheme", "PartyLegalEntity", "Contact", "Person", "AgentParty",
+		"ServiceProviderParty", "PowerOfAttorney", "FinancialAccount",
+	},
+	"PartyName": {"Name"},
+	"PostalAddress": {
+		"ID", "AddressTypeCode", "AddressFormatCode", "Postbox", "Floor", "Room", "StreetName",
+		"AdditionalStreetName", "BlockName", "BuildingName", "BuildingNumber", "InhouseMail", "Department",
+		"MarkAttention", "MarkCare", "PlotIdentification", "CitySubdivisionName", "CityName", "PostalZone",
+		"CountrySubentity", "CountrySubentityCode", "Region", "District", "TimezoneOffset", "AddressLine",
+		"Country", "LocationCoordinate",
+	},
+	"Country": {"IdentificationCode", "Name"},
+	"PartyLegalEntity": {
+		"RegistrationName", "CompanyID", "RegistrationDate", "RegistrationExpirationDate",
+		"CompanyLegalFormCode", "CompanyLegalForm", "SoleProprietorshipIndicator",
+		"CompanyLiquidationStatusCode", "CorporateStockAmount", "FullyPaidSharesIndicator",
+		"RegistrationAddress", "CorporateRegistrationScheme", "HeadOfficeParty", "ShareholderParty",
+	},
+	"Contact":     {"ID", "Name", "Telephone", "Telefax", "ElectronicMail", "Note", "OtherCommunication"},
+	"TaxTotal":    {"TaxAmount", "RoundingAmount", "TaxEvidenceIndicator", "TaxIncludedIndicator", "TaxSubtotal"},
+	"TaxSubtotal": {"TaxableAmount", "TaxAmount", "CalculationSequenceNumeric", "TransactionCurrencyTaxAmount", "Percent", "BaseUnitMeasure", "PerUnitAmount", "TierRange", "TierRatePercent", "TaxCategory"},
+	"TaxCategory": ublTaxCategorySequence,
+	"TaxScheme":   {"ID", "Name", "TaxTypeCode", "CurrencyCode", "JurisdictionRegionAddress"},
+	"LegalMonetaryTotal": {
+		"LineExtensionAmount", "TaxExclusiveAmount", "TaxInclusiveAmount", "AllowanceTotalAmount",
+		"ChargeTotalAmount", "PrepaidAmount", "PayableRoundingAmount", "PayableAmount", "PayableAlternativeAmount",
+	},
+	"InvoiceLine": {
+		"ID", "UUID", "Note", "InvoicedQuantity", "LineExtensionAmount", "TaxPointDate", "AccountingCostCode",
+		"AccountingCost", "PaymentPurposeCode", "FreeOfChargeIndicator", "InvoicePeriod", "OrderLineReference",
+		"DespatchLineReference", "ReceiptLineReference", "BillingReference", "DocumentReference",
+		"PricingReference", "OriginatorParty", "Delivery", "PaymentTerms", "AllowanceCharge", "TaxTotal",
+		"WithholdingTaxTotal", "Item", "Price", "DeliveryTerms", "SubInvoiceLine", "ItemPriceExtension",
+	},
+	"Item": {
+		"Description", "PackQuantity", "PackSizeNumeric", "CatalogueIndicator", "Name", "HazardousRiskIndicator",
+		"AdditionalInformation", "Keyword", "BrandName", "ModelName", "BuyersItemIdentification",
+		"SellersItemIdentification", "ManufacturersItemIdentification", "StandardItemIdentification",
+		"CatalogueItemIdentification", "AdditionalItemIdentification", "CatalogueDocumentReference",
+		"ItemSpecificationDocumentReference", "OriginCountry", "CommodityClassification",
+		"TransactionConditions", "HazardousItem", "ClassifiedTaxCategory", "AdditionalItemProperty",
+		"ManufacturerParty", "InformationContentProviderParty", "OriginAddress", "ItemInstance", "Certificate",
+		"Dimension",
+	},
+	"ClassifiedTaxCategory": ublTaxCategorySequence,
+	"Price":                 {"PriceAmount", "BaseQuantity", "PriceChangeReason", "PriceTypeCode", "PriceType", "OrderableUnitFactorRate", "ValidityPeriod", "PriceList", "AllowanceCharge", "PricingExchangeRate"},
+}
+
+var ublTaxCategorySequence = []string{"ID", "Name", "Percent", "BaseUnitMeasure", "PerUnitAmount", "TaxExemptionReasonCode", "TaxExemptionReason", "TierRange", "TierRatePercent", "TaxScheme"}
+
+var (
+	ublSupplierParty = []string{"AccountingSupplierParty", "Party"}
+	ublCustomerParty = []string{"AccountingCustomerParty", "Party"}
+)
+
+// ublRequired lists the elements EN 16931 and PEPPOL BIS Billing 3.0 require
+// of every invoice, by the rule requiring them
+var ublRequired = []struct {
+	rule string
+	path []string
+}{
+	{"BR-01", []string{"CustomizationID"}},
+	{"PEPPOL-EN16931-R001", []string{"ProfileID"}},
+	{"BR-02", []string{"ID"}},
+	{"BR-03", []string{"IssueDate"}},
+	{"BR-04", []string{"InvoiceTypeCode"}},
+	{"BR-05", []string{"DocumentCurrencyCode"}},
+	{"BR-06", append(ublSupplierParty, "PartyLegalEntity", "RegistrationName")},
+	{"BR-07", append(ublCustomerParty, "PartyLegalEntity", "RegistrationName")},
+	{"BR-08", append(ublSupplierParty, "PostalAddress")},
+	{"BR-09", append(ublSupplierParty, "PostalAddress", "Country", "IdentificationCode")},
+	{"BR-10", append(ublCustomerParty, "PostalAddress")},
+	{"BR-11", append(ublCustomerParty, "PostalAddress", "Country", "IdentificationCode")},
+	{"BR-12", []string{"LegalMonetaryTotal", "LineExtensionAmount"}},
+	{"BR-13", []string{"LegalMonetaryTotal", "TaxExclusiveAmount"}},
+	{"BR-14", []string{"LegalMonetaryTotal", "TaxInclusiveAmount"}},
+	{"BR-15", []string{"LegalMonetaryTotal", "PayableAmount"}},
+	{"BR-16", []string{"InvoiceLine"}},
+	{"BR-CO-18", []string{"TaxTotal", "TaxSubtotal"}},
+}
+
+// ublLineRequired lists the elements required of every invoice line
+var ublLineRequired = []struct {
+	rule string
+	path []string
+}{
+	{"BR-21", []string{"ID"}},
+	{"BR-22", []string{"InvoicedQuantity"}},
+	{"BR-24", []string{"LineExtensionAmount"}},
+	{"BR-25", []string{"Item", "Name"}},
+	{"BR-26", []string{"Price", "PriceAmount"}},
+	{"BR-CO-04", []string{"Item", "ClassifiedTaxCategory", "ID"}},
+}
+
+var ublDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+type xmlNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+func (n *xmlNode) child(path ...string) *xmlNode {
+	if len(path) == 0 {
+		return n
+	}
+	for _, c := range n.children {
+		if c.name.Local == path[0] {
+			return c.child(path[1:]...)
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) all(name string) []*xmlNode {
+	nodes := []*xmlNode{}
+	for _, c := range n.children {
+		if c.name.Local == name {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func (n *xmlNode) walk(fn func(*xmlNode)) {
+	fn(n)
+	for _, c := range n.children {
+		c.walk(fn)
+	}
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// checkSequence checks the order and namespaces of the children of n and of
+// the aggregates below it, naming elements by their path from the root
+func checkSequence(n *xmlNode, path string) []string {
+	sequence, ok := ublSequences[n.name.Local]
+	if !ok {
+		return nil
+	}
+
+	errs := []string{}
+	position := map[string]int{}
+	for p, name := range sequence {
+		position[name] = p
+	}
+	last := -1
+	for _, c := range n.children {
+		name := path + "/" + c.name.Local
+		p, ok := position[c.name.Local]
+		switch {
+		case !ok:
+			errs = append(errs, "Unexpected element "+name)
+		case p < last:
+			errs = append(errs, "Element out of order: "+name)
+		default:
+			last = p
+		}
+		if c.name.Space != ublNamespaceCBC && c.name.Space != ublNamespaceCAC {
+			errs = append(errs, "Element in wrong namespace: "+name)
+		}
+		errs = append(errs, checkSequence(c, name)...)
+	}
+	return errs
+}
+
+func parseXMLTree(doc []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	stack := []*xmlNode{{}}
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name, attrs: t.Attr}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			stack[len(stack)-1].text += strings.TrimSpace(string(t))
+		}
+	}
+	if len(stack[0].children) != 1 {
+		return nil, fmt.Errorf("Document must have a single root element")
+	}
+	return stack[0].children[0], nil
+}
+
+// checkUBL stands in for validation against the UBL 2.1 XSD, which is not
+// bundled with the repository. It checks the element order of the Invoice
+// schema for the aggregates in ublSequences only, the elements required by
+// EN 16931 and PEPPOL BIS Billing 3.0, and the EN 16931 rules for totals used
+// by this API. Elements and aggregates not listed are not checked at all, nor
+// are the PEPPOL rules for endpoint identifiers and buyer references, which
+// invoices only carry when they are known
+func checkUBL(doc []byte) []string {
+	root, err := parseXMLTree(doc)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if root.name.Space != ublNamespaceInvoice || root.name.Local != "Invoice" {
+		return []string{fmt.Sprintf("Root element must be {%v}Invoice, was {%v}%v", ublNamespaceInvoice, root.name.Space, root.name.Local)}
+	}
+
+	errs := checkSequence(root, "Invoice")
+	for _, r := range ublRequired {
+		if root.child(r.path...) == nil {
+			errs = append(errs, fmt.Sprintf("%v: Missing required element %v", r.rule, strings.Join(r.path, "/")))
+		}
+	}
+	for n, l := range root.all("InvoiceLine") {
+		for _, r := range ublLineRequired {
+			if l.child(r.path...) == nil {
+				errs = append(errs, fmt.Sprintf("%v: Missing required element InvoiceLine[%d]/%v", r.rule, n+1, strings.Join(r.path, "/")))
+			}
+		}
+		if q := l.child("InvoicedQuantity"); q != nil && q.attr("unitCode") == "" {
+			errs = append(errs, fmt.Sprintf("BR-23: InvoiceLine[%d]/InvoicedQuantity must have a unitCode", n+1))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for _, name := range []string{"IssueDate", "DueDate"} {
+		if n := root.child(name); n != nil && !ublDate.MatchString(n.text) {
+			errs = append(errs, fmt.Sprintf("%v must be a date, was %q", name, n.text))
+		}
+	}
+
+	currency := root.child("DocumentCurrencyCode").text
+	root.walk(func(n *xmlNode) {
+		if !strings.HasSuffix(n.name.Local, "Amount") {
+			return
+		}
+		if n.attr("currencyID") != currency {
+			errs = append(errs, fmt.Sprintf("%v must have currencyID=%v", n.name.Local, currency))
+		}
+		if _, err := parseDecimal(n.text); err != nil {
+			errs = append(errs, fmt.Sprintf("%v must be a decimal, was %q", n.name.Local, n.text))
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+
+	amount := func(path ...string) decimal {
+		n := root.child(path...)
+		if n == nil {
+			return decimal{}
+		}
+		d, _ := parseDecimal(n.text)
+		return d
+	}
+
+	lineSum := decimal{}
+	for _, l := range root.all("InvoiceLine") {
+		d, _ := parseDecimal(l.child("LineExtensionAmount").text)
+		lineSum = lineSum.add(d)
+	}
+	total := func(name string) decimal { return amount("LegalMonetaryTotal", name) }
+	if lineSum.cmp(total("LineExtensionAmount")) != 0 {
+		errs = append(errs, "BR-CO-10: Sum of line amounts must equal LineExtensionAmount")
+	}
+	if total("TaxExclusiveAmount").add(amount("TaxTotal", "TaxAmount")).cmp(total("TaxInclusiveAmount")) != 0 {
+		errs = append(errs, "BR-CO-15: TaxInclusiveAmount must equal TaxExclusiveAmount plus TaxAmount")
+	}
+	if total("TaxInclusiveAmount").sub(total("PrepaidAmount")).add(total("PayableRoundingAmount")).cmp(total("PayableAmount")) != 0 {
+		errs = append(errs, "BR-CO-16: PayableAmount must equal TaxInclusiveAmount minus PrepaidAmount plus PayableRoundingAmount")
+	}
+	return errs
+}
+
+func TestCheckUBL_Samples(t *testing.T) {
+	files, err := filepath.Glob("testdata/ubl/*.xml")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(files) == 0 {
+		t.Fatalf("Should find sample documents")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			doc, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if errs := checkUBL(doc); len(errs) > 0 {
+				t.Errorf("Sample should be valid: %v", errs)
+			}
+		})
+	}
+
+	t.Run("Rejects elements out of order", func(t *testing.T) {
+		doc, _ := ioutil.ReadFile("testdata/ubl/rounding.xml")
+		doc = bytes.Replace(doc, []byte("<cbc:Note>Rounded to whole kroner</cbc:Note>\n"), nil, 1)
+		doc = bytes.Replace(doc, []byte("</Invoice>"), []byte("<cbc:Note>Late</cbc:Note></Invoice>"), 1)
+		if errs := checkUBL(doc); len(errs) == 0 {
+			t.Errorf("Should reject document with elements out of order")
+		}
+	})
+
+	t.Run("Rejects nested elements out of order", func(t *testing.T) {
+		doc, _ := ioutil.ReadFile("testdata/ubl/rounding.xml")
+		doc = bytes.Replace(doc, []byte("<cbc:PayableRoundingAmount currencyID=\"NOK\">0.01</cbc:PayableRoundingAmount>\n"), nil, 1)
+		doc = bytes.Replace(doc, []byte("</cac:LegalMonetaryTotal>"), []byte("<cbc:PayableRoundingAmount currencyID=\"NOK\">0.01</cbc:PayableRoundingAmount></cac:LegalMonetaryTotal>"), 1)
+		if errs := checkUBL(doc); len(errs) == 0 {
+			t.Errorf("Should reject document with nested elements out of order")
+		}
+	})
+
+	t.Run("Rejects documents without the address of the buyer", func(t *testing.T) {
+		doc, _ := ioutil.ReadFile("testdata/ubl/rounding.xml")
+		doc = regexp.MustCompile(`(?s)(<cac:AccountingCustomerParty>.*?)<cac:PostalAddress>.*?</cac:PostalAddress>`).ReplaceAll(doc, []byte("$1"))
+		if errs := checkUBL(doc); len(errs) == 0 {
+			t.Errorf("Should reject document without the address of the buyer")
+		}
+	})
+
+	t.Run("Rejects inconsistent totals", func(t *testing.T) {
+		doc, _ := ioutil.ReadFile("testdata/ubl/rounding.xml")
+		doc = bytes.Replace(doc, []byte(`<cbc:PayableAmount currencyID="NOK">125.00`), []byte(`<cbc:PayableAmount currencyID="NOK">126.00`), 1)
+		if errs := checkUBL(doc); len(errs) == 0 {
+			t.Errorf("Should reject document with inconsistent totals")
+		}
+	})
+}
+
+func TestNewUBLInvoice(t *testing.T) {
+	issueDate := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+	now := issueDate.AddDate(0, 1, 0)
+	customer := customer{ID: 1, Name: "Kunde AS", Email: "faktura@kunde.no", Address: "Storgata 1, 0155 Oslo"}
+
+	for _, x := range []struct {
+		name    string
+		invoice invoice
+		id      string
+		prepaid string
+		payable string
+	}{
+		{
+			name:    "Invoice without lines",
+			invoice: invoice{ID: 1, Description: "Consulting", DueDate: issueDate.AddDate(0, 0, 14), IssuedAt: &issueDate, Amount: mustDecimal("1500"), Balance: mustDecimal("1500"), Currency: "NOK"},
+			id:      "1",
+			payable: "1500.00",
+		},
+		{
+			name: "Invoice with lines at several rates",
+			invoice: invoice{ID: 2, Number: "INV-2019-000002", IssuedAt: &issueDate, Amount: mustDecimal("1825"), Balance: mustDecimal("1825"), Currency: "EUR", Lines: []invoiceLine{
+				{Description: "Consulting", Quantity: mustDecimal("7"), UnitPrice: mustDecimal("400"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("3500")},
+				{Description: "Credit", Quantity: mustDecimal("-3"), UnitPrice: mustDecimal("500"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("-1875")},
+				{Description: "Books", Quantity: mustDecimal("2"), UnitPrice: mustDecimal("100"), Amount: mustDecimal("200")},
+			}},
+			id:      "INV-2019-000002",
+			payable: "1825.00",
+		},
+		{
+			name: "Invoice rounded per line",
+			invoice: invoice{ID: 3, Number: "INV-2019-000003", IssuedAt: &issueDate, Amount: mustDecimal("2.52"), Balance: mustDecimal("2.52"), Currency: "NOK", Lines: []invoiceLine{
+				{Description: "Screw", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("1.2625")},
+				{Description: "Nut", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("1.01"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("1.2625")},
+			}},
+			id:      "INV-2019-000003",
+			payable: "2.52",
+		},
+		{
+			name: "Invoice with tax inclusive prices",
+			invoice: invoice{ID: 4, Number: "INV-2019-000004", IssuedAt: &issueDate, TaxMode: taxInclusive, Amount: mustDecimal("224.99"), Balance: mustDecimal("224.99"), Currency: "NOK", Lines: []invoiceLine{
+				{Description: "Consulting", Quantity: mustDecimal("1"), UnitPrice: mustDecimal("125"), TaxRate: mustDecimal("0.25"), Amount: mustDecimal("125")},
+				{Description: "Lunch", Quantity: mustDecimal("3"), UnitPrice: mustDecimal("33.33"), TaxRate: mustDecimal("0.15"), Amount: mustDecimal("99.99")},
+			}},
+			id:      "INV-2019-000004",
+			payable: "224.99",
+		},
+		{
+			name:    "Invoice partly paid",
+			invoice: invoice{ID: 5, Number: "INV-2019-000005", IssuedAt: &issueDate, Amount: mustDecimal("1500"), Balance: mustDecimal("499.995"), Currency: "NOK"},
+			id:      "INV-2019-000005",
+			prepaid: "1000.01",
+			payable: "499.99",
+		},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			u, err := newUBLInvoice(invoiceDocument{invoice: x.invoice, customer: customer}, now)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			doc, err := xml.Marshal(u)
+			if err != nil {
+				t.Fatalf(err.Error())
+			}
+			if errs := checkUBL(doc); len(errs) > 0 {
+				t.Errorf("Should produce a valid document: %v\n%s", errs, doc)
+			}
+
+			root, _ := parseXMLTree(doc)
+			if id := root.child("ID").text; id != x.id {
+				t.Errorf("Should have ID %v, got %v", x.id, id)
+			}
+			if date := root.child("IssueDate").text; date != "2019-11-01" {
+				t.Errorf("Should be dated when issued, %v, got %v", "2019-11-01", date)
+			}
+			address := root.child("AccountingCustomerParty", "Party", "PostalAddress")
+			if address.child("StreetName").text != customer.Address || address.child("Country", "IdentificationCode").text != config.tax.jurisdiction {
+				t.Errorf("Should address the customer at %v in %v", customer.Address, config.tax.jurisdiction)
+			}
+			prepaid := ""
+			if n := root.child("LegalMonetaryTotal", "PrepaidAmount"); n != nil {
+				prepaid = n.text
+			}
+			if prepaid != x.prepaid {
+				t.Errorf("Should have PrepaidAmount %q, got %q", x.prepaid, prepaid)
+			}
+			if payable := root.child("LegalMonetaryTotal", "PayableAmount").text; payable != x.payable {
+				t.Errorf("Should have PayableAmount %v, got %v", x.payable, payable)
+			}
+		})
+	}
+
+	t.Run("Dates drafts when the document is produced", func(t *testing.T) {
+		u, err := newUBLInvoice(invoiceDocument{invoice: invoice{ID: 6, Amount: mustDecimal("10"), Balance: mustDecimal("10"), Currency: "NOK"}, customer: customer}, now)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		doc, err := xml.Marshal(u)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		root, _ := parseXMLTree(doc)
+		if date := root.child("IssueDate").text; date != "2019-12-01" {
+			t.Errorf("Should be dated %v, got %v", "2019-12-01", date)
+		}
+	})
+
+	t.Run("Rejects invoices without lines whose amount includes tax", func(t *testing.T) {
+		for _, i := range []invoice{
+			{ID: 7, Amount: mustDecimal("125"), Currency: "NOK", TaxMode: taxInclusive},
+			{ID: 8, Amount: mustDecimal("125"), TaxAmount: mustDecimal("25"), Currency: "NOK", TaxMode: taxExclusive},
+		} {
+			if _, err := newUBLInvoice(invoiceDocument{invoice: i, customer: customer}, now); err == nil {
+				t.Errorf("Should reject invoice %v", i.ID)
+			} else if _, ok := err.(ConflictError); !ok {
+				t.Errorf("Should return ConflictError, got %T", err)
+			}
+		}
+	})
+}
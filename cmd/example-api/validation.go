@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxAmount is the largest value that fits the decimal(12,4) columns
+var maxAmount = decimal{units: 999999999999}
+
+// maxTaxRate is the largest value that fits the decimal(5,4) tax rate column
+var maxTaxRate = newDecimal(1)
+
+// fieldError describes why a field of a request payload is invalid
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError represents the invalid fields of a request payload
+type ValidationError []fieldError
+
+func (e ValidationError) Error() string {
+	fields := make([]string, len(e))
+	for n, f := range e {
+		fields[n] = f.Field
+	}
+	return "Validation failed for fields: " + strings.Join(fields, ", ")
+}
+
+func validateRequired(field string, value string) ValidationError {
+	if strings.TrimSpace(value) == "" {
+		return ValidationError{{field, "required", "Value is required"}}
+	}
+	return nil
+}
+
+func validateMaxLength(field string, value string, max int) ValidationError {
+	if utf8.RuneCountInString(value) > max {
+		return ValidationError{{field, "max_length", fmt.Sprintf("Value must not be longer than %v characters", max)}}
+	}
+	return nil
+}
+
+func validateRange(field string, value decimal, min decimal, max decimal) ValidationError {
+	switch {
+	case value.cmp(min) < 0:
+		return ValidationError{{field, "min", fmt.Sprintf("Value must not be less than %v", min)}}
+	case value.cmp(max) > 0:
+		return ValidationError{{field, "max", fmt.Sprintf("Value must not be greater than %v", max)}}
+	}
+	return nil
+}
+
+func (i invoice) validate() ValidationError {
+	errs := ValidationError{}
+
+	switch {
+	case i.CustomerID == 0:
+		errs = append(errs, fieldError{"customerID", "required", "Value is required"})
+	case i.CustomerID < 0:
+		errs = append(errs, fieldError{"customerID", "min", "Value must be a positive integer"})
+	}
+	errs = append(errs, validateMaxLength("description", i.Description, 45)...)
+	errs = append(errs, validateRange("amount", i.Amount, decimal{}, maxAmount)...)
+	errs = append(errs, money{i.Amount, i.Currency}.validate("amount", "currency")...)
+	if i.TaxMode != "" && i.TaxMode != taxExclusive && i.TaxMode != taxInclusive {
+		errs = append(errs, fieldError{"taxMode", "invalid", "Value must be exclusive or inclusive"})
+	}
+	errs = append(errs, validateMaxLength("jurisdiction", i.Jurisdiction, 10)...)
+
+	lineErrs := ValidationError{}
+	for n, l := range i.Lines {
+		lineErrs = append(lineErrs, l.validate(fmt.Sprintf("lines[%d].", n))...)
+	}
+	errs = append(errs, lineErrs...)
+
+	// The gross total, including the tax of lines that state their rate, is
+	// never less than the net total. Rates looked up from the jurisdiction
+	// are only known once inserted, and checked again by updateTotal
+	if len(i.Lines) > 0 && len(lineErrs) == 0 {
+		b := computeTax(i.Lines, i.TaxMode, currencyMinorUnits[i.Currency])
+		if b.gross.cmp(maxAmount) > 0 {
+			errs = append(errs, fieldError{"amount", "max", fmt.Sprintf("Total of the lines must not be greater than %v", maxAmount)})
+		}
+	}
+	return errs
+}
+
+// validate checks the line, prefixing field names with prefix
+func (l invoiceLine) validate(prefix string) ValidationError {
+	errs := ValidationError{}
+
+	errs = append(errs, validateRequired(prefix+"description", l.Description)...)
+	errs = append(errs, validateMaxLength(prefix+"description", l.Description, 255)...)
+	errs = append(errs, validateMaxLength(prefix+"category", l.Category, 50)...)
+	if l.Quantity.isZero() {
+		errs = append(errs, fieldError{prefix + "quantity", "min", "Value must be greater than 0"})
+	}
+	errs = append(errs, validateRange(prefix+"quantity", l.Quantity, decimal{}, maxAmount)...)
+	errs = append(errs, validateRange(prefix+"unitPrice", l.UnitPrice, decimal{}, maxAmount)...)
+	if total, ok := l.Quantity.checkedMul(l.UnitPrice); !ok || total.cmp(maxAmount) > 0 {
+		errs = append(errs, fieldError{prefix + "unitPrice", "max", fmt.Sprintf("Quantity times unit price must not be greater than %v", maxAmount)})
+	}
+	errs = append(errs, validateRange(prefix+"taxRate", l.TaxRate, decimal{}, maxTaxRate)...)
+	return errs
+}
+
+func (c customer) validate() ValidationError {
+	errs := ValidationError{}
+
+	errs = append(errs, validateRequired("name", c.Name)...)
+	errs = append(errs, validateMaxLength("name", c.Name, 255)...)
+	errs = append(errs, validateMaxLength("email", c.Email, 255)...)
+	if c.Email != "" && !strings.Contains(c.Email, "@") {
+		errs = append(errs, fieldError{"email", "invalid", "Value must be an email address"})
+	}
+	errs = append(errs, validateMaxLength("address", c.Address, 255)...)
+	return errs
+}
+
+// validate checks the payment. The precision of the amount depends on the
+// currency of the invoice, and is checked when the payment is recorded
+func (p payment) validate() ValidationError {
+	errs := ValidationError{}
+
+	if p.Amount.isNegative() || p.Amount.isZero() {
+		errs = append(errs, fieldError{"amount", "min", "Value must be greater than 0"})
+	} else {
+		errs = append(errs, validateRange("amount", p.Amount, decimal{}, maxAmount)...)
+	}
+	errs = append(errs, validateMaxLength("reference", p.Reference, 255)...)
+	return errs
+}
+
+func (c creditNote) validate() ValidationError {
+	errs := ValidationError{}
+
+	if c.Amount.isNegative() || c.Amount.isZero() {
+		errs = append(errs, fieldError{"amount", "min", "Value must be greater than 0"})
+	} else {
+		errs = append(errs, validateRange("amount", c.Amount, decimal{}, maxAmount)...)
+	}
+	errs = append(errs, validateMaxLength("reason", c.Reason, 255)...)
+	return errs
+}
+
+func (r taxRate) validate() ValidationError {
+	errs := ValidationError{}
+
+	errs = append(errs, validateMaxLength("jurisdiction", r.Jurisdiction, 10)...)
+	errs = append(errs, validateMaxLength("category", r.Category, 50)...)
+	errs = append(errs, validateRange("rate", r.Rate, decimal{}, maxTaxRate)...)
+	return errs
+}
+
+// maxEvery is the largest number of units between the runs of a schedule
+const maxEvery = 1000
+
+// validate checks the schedule, including the invoice fields copied to the
+// invoices it creates
+func (s schedule) validate() ValidationError {
+	errs := s.invoice(s.Anchor).validate()
+
+	if s.Series != "" {
+		if _, ok := config.numbering.series[s.Series]; !ok {
+			errs = append(errs, fieldError{"series", "unknown", fmt.Sprintf("Number series %q is not configured", s.Series)})
+		}
+	}
+
+	switch {
+	case s.Cron != "" && (s.Every != 0 || s.Unit != ""):
+		errs = append(errs, fieldError{"cron", "invalid", "Value must not be given along with every and unit"})
+	case s.Cron != "":
+		if _, err := parseCron(s.Cron); err != nil {
+			errs = append(errs, fieldError{"cron", "invalid", err.Error()})
+		} else if _, ok := s.firstRun(s.Anchor); !ok {
+			errs = append(errs, fieldError{"cron", "invalid", "Expression never matches"})
+		}
+	default:
+		if !s.Unit.valid() {
+			errs = append(errs, fieldError{"unit", "invalid", "Value must be day, week, month or year, unless cron is given"})
+		}
+		switch {
+		case s.Every < 1:
+			errs = append(errs, fieldError{"every", "min", "Value must be greater than 0"})
+		case s.Every > maxEvery:
+			errs = append(errs, fieldError{"every", "max", fmt.Sprintf("Value must not be greater than %v", maxEvery)})
+		}
+	}
+
+	if s.DueDays < 0 {
+		errs = append(errs, fieldError{"dueDays", "min", "Value must not be negative"})
+	}
+	if s.CatchUp != "" && s.CatchUp != catchUpAll && s.CatchUp != catchUpLatest {
+		errs = append(errs, fieldError{"catchUp", "invalid", "Value must be all or latest"})
+	}
+	return errs
+}
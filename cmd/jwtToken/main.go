@@ -21,10 +21,35 @@ func main() {
 
 	hmacSampleSecret := []byte(secret)
 
+	tenant := os.Getenv("TENANT")
+	if tenant == "" {
+		tenant = "default"
+	}
+
 	type invoicesClaims struct {
-		GetInvoices   bool `json:"getInvoices,omitempty"`
-		GetInvoice    bool `json:"getInvoice,omitempty"`
-		CreateInvoice bool `json:"createInvoice,omitempty"`
+		GetInvoices        bool   `json:"getInvoices,omitempty"`
+		GetInvoice         bool   `json:"getInvoice,omitempty"`
+		CreateInvoice      bool   `json:"createInvoice,omitempty"`
+		UpdateInvoice      bool   `json:"updateInvoice,omitempty"`
+		DeleteInvoice      bool   `json:"deleteInvoice,omitempty"`
+		RestoreInvoice     bool   `json:"restoreInvoice,omitempty"`
+		GetDeletedInvoices bool   `json:"getDeletedInvoices,omitempty"`
+		GetInvoiceHistory  bool   `json:"getInvoiceHistory,omitempty"`
+		IssueInvoice       bool   `json:"issueInvoice,omitempty"`
+		PayInvoice         bool   `json:"payInvoice,omitempty"`
+		VoidInvoice        bool   `json:"voidInvoice,omitempty"`
+		CreatePayment      bool   `json:"createPayment,omitempty"`
+		CreateCreditNote   bool   `json:"createCreditNote,omitempty"`
+		GetTaxRates        bool   `json:"getTaxRates,omitempty"`
+		UpdateTaxRates     bool   `json:"updateTaxRates,omitempty"`
+		GetSchedules       bool   `json:"getSchedules,omitempty"`
+		UpdateSchedules    bool   `json:"updateSchedules,omitempty"`
+		GetCustomers       bool   `json:"getCustomers,omitempty"`
+		GetCustomer        bool   `json:"getCustomer,omitempty"`
+		CreateCustomer     bool   `json:"createCustomer,omitempty"`
+		UpdateCustomer     bool   `json:"updateCustomer,omitempty"`
+		DeleteCustomer     bool   `json:"deleteCustomer,omitempty"`
+		Tenant             string `json:"tenant"`
 	}
 
 	type Claims struct {
@@ -34,11 +59,32 @@ func main() {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		invoicesClaims{
-			GetInvoices: true,
-			GetInvoice: true,
-			CreateInvoice: true,
+			GetInvoices:        true,
+			GetInvoice:         true,
+			CreateInvoice:      true,
+			UpdateInvoice:      true,
+			DeleteInvoice:      true,
+			RestoreInvoice:     true,
+			GetDeletedInvoices: true,
+			GetInvoiceHistory:  true,
+			IssueInvoice:       true,
+			PayInvoice:         true,
+			VoidInvoice:        true,
+			CreatePayment:      true,
+			CreateCreditNote:   true,
+			GetTaxRates:        true,
+			UpdateTaxRates:     true,
+			GetSchedules:       true,
+			UpdateSchedules:    true,
+			GetCustomers:       true,
+			GetCustomer:        true,
+			CreateCustomer:     true,
+			UpdateCustomer:     true,
+			DeleteCustomer:     true,
+			Tenant:             tenant,
 		},
 		jwt.StandardClaims{
+			Subject:   "dev",
 			ExpiresAt: getExpiry(),
 		},
 	})
@@ -59,4 +105,4 @@ func getExpiry() int64 {
 	}
 
 	return timestamp.Add(duration).Unix()
-}
\ No newline at end of file
+}
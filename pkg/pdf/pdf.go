@@ -0,0 +1,169 @@
+// Package pdf writes simple PDF documents consisting of text and lines, using
+// the standard Helvetica fonts which every PDF reader provides
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+// Font selects one of the standard fonts
+type Font int
+
+// Fonts available to documents
+const (
+	Regular Font = iota
+	Bold
+)
+
+var fontNames = []string{"Helvetica", "Helvetica-Bold"}
+
+// Document is a PDF document under construction
+type Document struct {
+	title string
+	pages []*Page
+}
+
+// Page is a single A4 page of a document. Coordinates are given in points
+// from the top left corner of the page
+type Page struct {
+	content bytes.Buffer
+}
+
+// New returns an empty document with the given title
+func New(title string) *Document {
+	return &Document{title: title}
+}
+
+// AddPage appends a new page to the document
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws s with its baseline starting at x, y
+func (p *Page) Text(x, y float64, font Font, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %s Tf %s %s Td (%s) Tj ET\n",
+		font+1, num(size), num(x), num(PageHeight-y), escape(encode(s)))
+}
+
+// TextRight draws s with its baseline ending at x, y
+func (p *Page) TextRight(x, y float64, font Font, size float64, s string) {
+	p.Text(x-TextWidth(s, size), y, font, size, s)
+}
+
+// Line draws a line from x1, y1 to x2, y2
+func (p *Page) Line(x1, y1, x2, y2 float64, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// TextWidth returns the width of s when drawn in the given size. Both fonts
+// are measured by the widths of Helvetica, which Helvetica-Bold only exceeds
+// slightly
+func TextWidth(s string, size float64) float64 {
+	width := 0
+	for _, c := range encode(s) {
+		if c >= 32 && int(c-32) < len(helveticaWidths) {
+			width += helveticaWidths[c-32]
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// WriteTo writes the document to w
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-4 are the catalog, the page tree, the info dictionary and the
+	// fonts, followed by a page and a content stream object for each page
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for n := range d.pages {
+		kids[n] = fmt.Sprintf("%d 0 R", firstPage+2*n)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (go-example-api) >>", escape(encode(d.title))))
+	for _, name := range fontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+	}
+	for n, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), firstPage+2*n+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.WriteTo(w)
+}
+
+// encode converts s to WinAnsiEncoding, which matches Latin-1 for the
+// characters commonly used. Other characters are replaced by '?'
+func encode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '€':
+			b = append(b, 0x80)
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b = append(b, '?')
+		default:
+			b = append(b, byte(r))
+		}
+	}
+	return b
+}
+
+// escape escapes the characters with special meaning in PDF strings
+func escape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c == '\\' || c == '(' || c == ')' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// num formats a number without exponent and superfluous zeros
+func num(f float64) string {
+	s := strings.TrimRight(fmt.Sprintf("%.2f", f), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// helveticaWidths holds the widths of the printable ASCII characters of
+// Helvetica in thousandths of the font size
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space-/
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0-?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @-O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P-_
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // `-o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p-~
+}
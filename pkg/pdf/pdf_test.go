@@ -0,0 +1,67 @@
+package pdf
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDocument_WriteTo(t *testing.T) {
+	doc := New("Invoice (1)")
+	p := doc.AddPage()
+	p.Text(50, 50, Bold, 20, "Invoice")
+	p.TextRight(545, 80, Regular, 10, "Total: 1 024,50 €")
+	p.Line(50, 90, 545, 90, 0.5)
+	doc.AddPage().Text(50, 50, Regular, 10, `Back\slash (parens)`)
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	out := buf.String()
+
+	t.Run("Has header and trailer", func(t *testing.T) {
+		if !strings.HasPrefix(out, "%PDF-1.4\n") || !strings.HasSuffix(out, "%%EOF\n") {
+			t.Errorf("Should start with a PDF header and end with EOF marker")
+		}
+		if !strings.Contains(out, "/Count 2") {
+			t.Errorf("Should have 2 pages")
+		}
+	})
+
+	t.Run("Cross reference table points to objects", func(t *testing.T) {
+		m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(out)
+		if m == nil {
+			t.Fatalf("Should have startxref")
+		}
+		xref, _ := strconv.Atoi(m[1])
+		if !strings.HasPrefix(out[xref:], "xref\n") {
+			t.Fatalf("startxref should point to the xref table")
+		}
+
+		entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out[xref:], -1)
+		for n, e := range entries {
+			offset, _ := strconv.Atoi(e[1])
+			if expected := strconv.Itoa(n+1) + " 0 obj"; !strings.HasPrefix(out[offset:], expected) {
+				t.Errorf("Entry %v should point to %q", n+1, expected)
+			}
+		}
+	})
+
+	t.Run("Escapes strings", func(t *testing.T) {
+		if !strings.Contains(out, `(Back\\slash \(parens\))`) || !strings.Contains(out, `/Title (Invoice \(1\))`) {
+			t.Errorf("Should escape backslashes and parentheses")
+		}
+		if !strings.Contains(out, "1 024,50 \x80") {
+			t.Errorf("Should encode text as WinAnsiEncoding")
+		}
+	})
+}
+
+func TestTextWidth(t *testing.T) {
+	if w := TextWidth("100", 10); w != 16.68 {
+		t.Errorf("Expected %v, got %v", 16.68, w)
+	}
+}
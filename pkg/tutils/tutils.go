@@ -13,27 +13,78 @@ import (
 
 // InvoicesClaims defines the JWT claims available in the application
 type InvoicesClaims struct {
-	GetInvoices   bool `json:"getInvoices,omitempty"`
-	GetInvoice    bool `json:"getInvoice,omitempty"`
-	CreateInvoice bool `json:"createInvoice,omitempty"`
+	GetInvoices        bool   `json:"getInvoices,omitempty"`
+	GetInvoice         bool   `json:"getInvoice,omitempty"`
+	CreateInvoice      bool   `json:"createInvoice,omitempty"`
+	UpdateInvoice      bool   `json:"updateInvoice,omitempty"`
+	DeleteInvoice      bool   `json:"deleteInvoice,omitempty"`
+	RestoreInvoice     bool   `json:"restoreInvoice,omitempty"`
+	GetDeletedInvoices bool   `json:"getDeletedInvoices,omitempty"`
+	GetInvoiceHistory  bool   `json:"getInvoiceHistory,omitempty"`
+	IssueInvoice       bool   `json:"issueInvoice,omitempty"`
+	PayInvoice         bool   `json:"payInvoice,omitempty"`
+	VoidInvoice        bool   `json:"voidInvoice,omitempty"`
+	CreatePayment      bool   `json:"createPayment,omitempty"`
+	CreateCreditNote   bool   `json:"createCreditNote,omitempty"`
+	GetTaxRates        bool   `json:"getTaxRates,omitempty"`
+	UpdateTaxRates     bool   `json:"updateTaxRates,omitempty"`
+	GetSchedules       bool   `json:"getSchedules,omitempty"`
+	UpdateSchedules    bool   `json:"updateSchedules,omitempty"`
+	GetCustomers       bool   `json:"getCustomers,omitempty"`
+	GetCustomer        bool   `json:"getCustomer,omitempty"`
+	CreateCustomer     bool   `json:"createCustomer,omitempty"`
+	UpdateCustomer     bool   `json:"updateCustomer,omitempty"`
+	DeleteCustomer     bool   `json:"deleteCustomer,omitempty"`
+	Tenant             string `json:"tenant,omitempty"`
 }
 
-// GenerateToken generates a JWT token using the provided JWT secret and InvoicesClaims
+// AllClaims returns InvoicesClaims granting every permission to the given tenant
+func AllClaims(tenant string) InvoicesClaims {
+	return InvoicesClaims{
+		GetInvoices:        true,
+		GetInvoice:         true,
+		CreateInvoice:      true,
+		UpdateInvoice:      true,
+		DeleteInvoice:      true,
+		RestoreInvoice:     true,
+		GetDeletedInvoices: true,
+		GetInvoiceHistory:  true,
+		IssueInvoice:       true,
+		PayInvoice:         true,
+		VoidInvoice:        true,
+		CreatePayment:      true,
+		CreateCreditNote:   true,
+		GetTaxRates:        true,
+		UpdateTaxRates:     true,
+		GetSchedules:       true,
+		UpdateSchedules:    true,
+		GetCustomers:       true,
+		GetCustomer:        true,
+		CreateCustomer:     true,
+		UpdateCustomer:     true,
+		DeleteCustomer:     true,
+		Tenant:             tenant,
+	}
+}
+
+// GenerateToken generates a JWT token using the provided JWT secret and InvoicesClaims.
+// The token belongs to claims.Tenant, or to the "test" tenant if none is given
 func GenerateToken(jwtSecret string, claims InvoicesClaims) string {
 	hmacSampleSecret := []byte(jwtSecret)
 
+	if claims.Tenant == "" {
+		claims.Tenant = "test"
+	}
+
 	type Claims struct {
 		InvoicesClaims
 		jwt.StandardClaims
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		InvoicesClaims{
-			GetInvoices:   true,
-			GetInvoice:    true,
-			CreateInvoice: true,
-		},
+		claims,
 		jwt.StandardClaims{
+			Subject:   "test",
 			ExpiresAt: getExpiry(),
 		},
 	})